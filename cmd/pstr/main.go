@@ -1,14 +1,92 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/rubuy-74/pstr/internal/parser"
-	"github.com/rubuy-74/pstr/internal/state_machine"
+	"github.com/rubuy-74/pstr/internal/regex"
 )
 
+// maxInputLength is the length in bytes of the longest string a request can match
+const maxInputLength = 1 << 20
+
 type RegexRequest struct {
 	Regex       string `json:"regex"`
 	MatchString string `json:"string"`
+	Engine      string `json:"engine"`
+	Flags       string `json:"flags"`
+}
+
+/*
+requestError is a request that cannot be handled, answered with a 400
+whose "error" is the title and "message" the underlying error.
+*/
+type requestError struct {
+	title string
+	err   error
+}
+
+func (e *requestError) Error() string {
+	return e.title + ": " + e.err.Error()
+}
+
+// compileRegex compiles the regex of the request with the requested flags
+// and engine, or the engine the regex needs when none is requested,
+// once the string of the request is known not to be too long to match
+func compileRegex(regexRequest *RegexRequest) (*regex.Regex, error) {
+	if len(regexRequest.MatchString) > maxInputLength {
+		return nil, &requestError{"string too long", fmt.Errorf("string of %d bytes exceeds the maximum of %d", len(regexRequest.MatchString), maxInputLength)}
+	}
+
+	flags, err := parser.ParseFlags(regexRequest.Flags)
+	if err != nil {
+		return nil, &requestError{"invalid flags", err}
+	}
+
+	engine := regex.EngineAuto
+	if regexRequest.Engine != "" {
+		engine, err = regex.ParseEngine(regexRequest.Engine)
+		if err != nil {
+			return nil, &requestError{"invalid engine", err}
+		}
+	}
+
+	re, err := regex.CompileWithOptions(regexRequest.Regex, regex.Options{
+		Flags:  flags,
+		Engine: engine,
+	})
+	if err != nil {
+		return nil, &requestError{"failed to parse regex", err}
+	}
+	return re, nil
+}
+
+// badRequest answers the request with a 400 describing err
+func badRequest(c *fiber.Ctx, err error) error {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return c.Status(400).JSON(fiber.Map{
+			"error":   reqErr.title,
+			"message": reqErr.err.Error(),
+		})
+	}
+	return c.Status(400).JSON(fiber.Map{"error": err.Error()})
+}
+
+// submatchGroups returns the text of each capturing group of a match,
+// or nil for a group that did not take part in it
+func submatchGroups(input string, index []int) []any {
+	groups := []any{}
+	for i := 2; i < len(index); i += 2 {
+		if index[i] < 0 {
+			groups = append(groups, nil)
+			continue
+		}
+		groups = append(groups, input[index[i]:index[i+1]])
+	}
+	return groups
 }
 
 func main() {
@@ -24,27 +102,46 @@ func main() {
 			return c.Status(400).JSON(fiber.Map{"error": "cannot parse JSON"})
 		}
 
-		regex := regexRequest.Regex
-		matchString := regexRequest.MatchString
+		re, err := compileRegex(regexRequest)
+		if err != nil {
+			return badRequest(c, err)
+		}
 
-		parsedRegex, err := parser.Parse(regex)
+		valid, err := re.Check(regexRequest.MatchString)
 		if err != nil {
-			return c.Status(400).JSON(fiber.Map{
-				"error":   "failed to parse regex",
-				"message": err,
-			})
+			return badRequest(c, &requestError{"failed to match regex", err})
+		}
+		return c.JSON(fiber.Map{
+			"valid": valid,
+		})
+	})
+
+	app.Post("/find", func(c *fiber.Ctx) error {
+		regexRequest := new(RegexRequest)
+		if err := c.BodyParser(regexRequest); err != nil {
+			return c.Status(400).JSON(fiber.Map{"error": "cannot parse JSON"})
 		}
 
-		nfa, err := state_machine.ToNFA(parsedRegex)
+		re, err := compileRegex(regexRequest)
 		if err != nil {
-			return c.Status(400).JSON(fiber.Map{
-				"error":   "failed to create NFA",
-				"message": err,
+			return badRequest(c, err)
+		}
+
+		index, err := re.FindSubmatchIndex(regexRequest.MatchString)
+		if err != nil {
+			return badRequest(c, &requestError{"failed to match regex", err})
+		}
+		if index == nil {
+			return c.JSON(fiber.Map{
+				"found": false,
 			})
 		}
-		valid := nfa.Check(matchString, -1)
 		return c.JSON(fiber.Map{
-			"valid": valid,
+			"found":  true,
+			"match":  regexRequest.MatchString[index[0]:index[1]],
+			"index":  index[:2],
+			"groups": submatchGroups(regexRequest.MatchString, index),
+			"named":  re.SubmatchMap(regexRequest.MatchString, index),
 		})
 	})
 
@@ -0,0 +1,732 @@
+package backtrack
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rubuy-74/pstr/internal/models/state"
+	"github.com/rubuy-74/pstr/internal/models/token"
+	"github.com/rubuy-74/pstr/internal/models/token_type"
+	"github.com/rubuy-74/pstr/internal/utils"
+)
+
+/*
+ErrStepLimit is returned when a match would take more steps than its
+budget (see stepsPerPoint), which only happens for token trees with
+backreferences, or when the program and the input are too large for
+the memo of the points tried (see maxPoints).
+*/
+var ErrStepLimit = errors.New("backtracking step limit exceeded")
+
+const (
+	/*
+		stepsPerPoint is the number of steps a match may take per point,
+		an instruction at a position of the input. Without backreferences,
+		an instruction is only run once at a position, along with the
+		paths reaching it again, which fail right away.
+	*/
+	stepsPerPoint = 8
+	// minSteps is the budget of the matches whose points allow fewer steps
+	minSteps = 1 << 20
+	// maxPoints is the number of points the memo of a match can hold
+	maxPoints = 1 << 28
+)
+
+// opcode is the operation of an instruction
+type opcode uint8
+
+const (
+	// opChar matches the byte ch
+	opChar opcode = iota
+	// opRanges matches a character of ranges, sorted and merged
+	opRanges
+	// opSplit goes on at each of next in priority order, a jump when there is only one
+	opSplit
+	// opAssert matches nothing where the assertion holds
+	opAssert
+	// opSave records the position in the capture slot
+	opSave
+	// opBackref matches the text captured by the group in slot, regardless of case with fold set
+	opBackref
+	// opAtomic matches its body, the instructions following it, once, and goes on at after
+	opAtomic
+	// opLook matches nothing where its body matches (see matcher.lookaround), and goes on at after
+	opLook
+	// opMatch ends a program or a body
+	opMatch
+)
+
+/*
+inst is an instruction of a program, which goes on at the next one
+when it matches, unless its opcode says otherwise. A join is an
+instruction more than one path can reach, where the matcher notes
+the positions it is run at (see matcher.run). A loop is the split
+ending the copy of an unbounded repetition, whose after leaves it.
+*/
+type inst struct {
+	op        opcode
+	join      bool
+	loop      bool
+	ch        byte
+	ranges    []token.BracketPayload
+	next      []int
+	assertion state.Assertion
+	slot      int
+	fold      bool
+	after     int
+	behind    bool
+	negated   bool
+	minWidth  int
+	maxWidth  int
+}
+
+/*
+compiler turns a token tree into a program with the shape of the NFA
+built from it (see token.ToNFA): alternations and repetitions choose
+between the same paths, in the same order, so the first match the
+matcher finds is the leftmost-first one, as with the NFA.
+- weight is the number of points each instruction stands for: a
+lookbehind runs its body at every position it can start from
+- cost adds up the weights of the instructions
+*/
+type compiler struct {
+	prog   []inst
+	weight int
+	cost   int
+}
+
+// compile returns the program of a token tree and its cost (see compiler)
+func compile(tokens []token.Token) ([]inst, int) {
+	c := &compiler{weight: 1}
+	c.sequence(tokens)
+	c.emit(inst{op: opMatch})
+
+	// the start of the program and of the bodies are run from
+	// several positions, the other instructions are joins when they
+	// follow more than one instruction
+	c.prog[0].join = true
+	from := make([]int, len(c.prog))
+	for pc, in := range c.prog {
+		switch in.op {
+		case opSplit:
+			for _, next := range in.next {
+				from[next]++
+			}
+		case opAtomic, opLook:
+			c.prog[pc+1].join = true
+			from[in.after]++
+		case opMatch:
+		default:
+			from[pc+1]++
+		}
+	}
+	for pc := range c.prog {
+		c.prog[pc].join = c.prog[pc].join || from[pc] > 1
+	}
+	return c.prog, c.cost
+}
+
+// emit appends an instruction to the program and returns its index
+func (c *compiler) emit(in inst) int {
+	c.prog = append(c.prog, in)
+	c.cost += c.weight
+	return len(c.prog) - 1
+}
+
+// sequence compiles tokens one after the other
+func (c *compiler) sequence(tokens []token.Token) {
+	for _, t := range tokens {
+		c.token(t)
+	}
+}
+
+/*
+token compiles a single token.
+- groups save their start and end in the capture slots
+- alternations split to their branches from left to right, which all
+jump past the last one
+- atomic groups and lookarounds are followed by their body
+*/
+func (c *compiler) token(t token.Token) {
+	if assertion, ok := token.Assertions[t.TokenType]; ok {
+		c.emit(inst{op: opAssert, assertion: assertion})
+		return
+	}
+
+	switch t.TokenType {
+	case token_type.Literal:
+		ch, _ := t.Value.(byte)
+		if t.Fold {
+			ranges := token.FoldRanges([]token.BracketPayload{{Begin: rune(ch), End: rune(ch)}})
+			c.emit(inst{op: opRanges, ranges: token.NormalizeRanges(ranges)})
+			return
+		}
+		c.emit(inst{op: opChar, ch: ch})
+
+	case token_type.Bracket, token_type.Dot:
+		ranges, _ := t.Value.([]token.BracketPayload)
+		c.emit(inst{op: opRanges, ranges: token.NormalizeRanges(ranges)})
+
+	case token_type.GroupUncaptured:
+		c.sequence(t.Children())
+
+	case token_type.Group:
+		payload, _ := t.Value.(token.GroupPayload)
+		c.emit(inst{op: opSave, slot: 2 * payload.Index})
+		c.sequence(payload.Tokens)
+		c.emit(inst{op: opSave, slot: 2*payload.Index + 1})
+
+	case token_type.Or:
+		split := c.emit(inst{op: opSplit})
+		jumps := []int{}
+		for _, branch := range t.Children() {
+			c.prog[split].next = append(c.prog[split].next, len(c.prog))
+			c.token(branch)
+			jumps = append(jumps, c.emit(inst{op: opSplit}))
+		}
+		for _, jump := range jumps {
+			c.prog[jump].next = []int{len(c.prog)}
+		}
+
+	case token_type.Repeat:
+		payload, _ := t.Value.(token.RepeatPayload)
+		c.repeat(payload)
+
+	case token_type.Atomic:
+		c.body(inst{op: opAtomic}, t.Children())
+
+	case token_type.Backref:
+		index, _ := t.Value.(int)
+		c.emit(inst{op: opBackref, slot: 2 * index, fold: t.Fold})
+
+	case token_type.Lookahead, token_type.Lookbehind:
+		payload, _ := t.Value.(token.LookaroundPayload)
+		look := inst{
+			op:       opLook,
+			behind:   t.TokenType == token_type.Lookbehind,
+			negated:  payload.Negated,
+			minWidth: payload.MinWidth,
+			maxWidth: payload.MaxWidth,
+		}
+		if !look.behind {
+			c.body(look, payload.Tokens)
+			return
+		}
+		weight := c.weight
+		c.weight *= 2*payload.MaxWidth + 1
+		c.body(look, payload.Tokens)
+		c.weight = weight
+
+	default:
+		panic("unknown type of token")
+	}
+}
+
+// body compiles an instruction followed by its body, tokens ended by opMatch
+func (c *compiler) body(in inst, tokens []token.Token) {
+	pc := c.emit(in)
+	c.sequence(tokens)
+	c.emit(inst{op: opMatch})
+	c.prog[pc].after = len(c.prog)
+}
+
+/*
+repeat compiles the copies of a repetition like its NFA (see
+token.RepeatPayload.ToNFA): for an infinite Max and a Min of 0, a split
+to a copy jumping back to it, or a split skipping a copy followed by a
+loop when the token can match nothing, otherwise Min-1 copies, then a
+copy followed by a loop, a split back to its start, and for a bounded
+Max, Min copies, then Max-Min copies, each after a split that can skip
+the remaining ones.
+*/
+func (c *compiler) repeat(rp token.RepeatPayload) {
+	if rp.Max == utils.Infinite {
+		if rp.Min == 0 && !rp.Token.Nullable() {
+			loop := c.emit(inst{op: opSplit, loop: true})
+			c.token(rp.Token)
+			c.emit(inst{op: opSplit, next: []int{loop}})
+			c.prog[loop].next = choice(rp, loop+1, len(c.prog))
+			c.prog[loop].after = len(c.prog)
+			return
+		}
+
+		for i := 1; i < rp.Min; i++ {
+			c.token(rp.Token)
+		}
+		skip := -1
+		if rp.Min == 0 {
+			skip = c.emit(inst{op: opSplit})
+		}
+		enter := len(c.prog)
+		c.token(rp.Token)
+		loop := c.emit(inst{op: opSplit, loop: true})
+		c.prog[loop].next = choice(rp, enter, loop+1)
+		c.prog[loop].after = loop + 1
+		if skip >= 0 {
+			c.prog[skip].next = choice(rp, enter, loop+1)
+		}
+		return
+	}
+
+	for i := 0; i < rp.Min; i++ {
+		c.token(rp.Token)
+	}
+	splits := []int{}
+	for i := rp.Min; i < rp.Max; i++ {
+		splits = append(splits, c.emit(inst{op: opSplit}))
+		c.token(rp.Token)
+	}
+	for _, split := range splits {
+		c.prog[split].next = choice(rp, split+1, len(c.prog))
+	}
+}
+
+// choice orders entering a copy and skipping it by the greediness of the repetition
+func choice(rp token.RepeatPayload, enter int, skip int) []int {
+	if rp.Lazy {
+		return []int{skip, enter}
+	}
+	return []int{enter, skip}
+}
+
+/*
+memo is a set of points, instructions at positions of the input, those
+from first on at the positions from lo on.
+*/
+type memo struct {
+	bits  []uint64
+	first int
+	lo    int
+	width int
+}
+
+func newMemo(first int, instructions int, lo int, width int) *memo {
+	return &memo{
+		bits:  make([]uint64, (instructions*width+63)/64),
+		first: first,
+		lo:    lo,
+		width: width,
+	}
+}
+
+func (mm *memo) key(pc int, pos int) int {
+	return (pc-mm.first)*mm.width + pos - mm.lo
+}
+
+func (mm *memo) has(key int) bool {
+	return mm.bits[key/64]&(1<<(key%64)) != 0
+}
+
+func (mm *memo) add(key int) {
+	mm.bits[key/64] |= 1 << (key % 64)
+}
+
+func (mm *memo) remove(key int) {
+	mm.bits[key/64] &^= 1 << (key % 64)
+}
+
+// entryKind is the kind of an entry of the backtracking stack
+type entryKind uint8
+
+const (
+	// tryEntry is a path to try on failure, at instruction a and position b
+	tryEntry entryKind = iota
+	// restoreEntry puts back the value b of capture slot a on failure
+	restoreEntry
+	// markEntry is a point on the path, instruction a at position b (see matcher.run)
+	markEntry
+)
+
+type entry struct {
+	kind entryKind
+	a    int
+	b    int
+}
+
+/*
+frame is how a program or a body is run.
+- target is the position it must end at, -1 for any
+- memo holds the points it reached
+- body makes it the body of an atomic group or a lookahead, which
+reaches the same points wherever it is run from, and records the
+path of its first match (see success)
+*/
+type frame struct {
+	target int
+	memo   *memo
+	body   bool
+}
+
+/*
+success is the first match of a body from a point on its path: the
+position it ended at, and the capture slots written from that point
+on, with their values at the end.
+*/
+type success struct {
+	end   int
+	caps  []int
+	slots []int
+}
+
+// ref is a point on the path of a success, which wrote the slots from the from-th one on
+type ref struct {
+	success *success
+	from    int
+}
+
+/*
+matcher runs a program against an input by backtracking, from an
+explicit stack of the paths left to try, the restores of the capture
+slots and the points on the path, so the depth of the Go stack only
+depends on the nesting of atomic groups and lookarounds.
+- caps holds the capture slots, with the layout of the NFA search
+(see state.FindSubmatchIndex)
+- memo holds the points already reached, where a later path stops
+(see run)
+- refs holds the points on the path of a body's first match
+- pathOnly only notes the loops on the path, for the trees with
+backreferences, which match differently depending on the captures
+- steps counts the instructions run, until maxSteps (see stepsPerPoint)
+*/
+type matcher struct {
+	prog     []inst
+	input    string
+	caps     []int
+	stack    []entry
+	memo     *memo
+	refs     map[int]ref
+	pathOnly bool
+	steps    int
+	maxSteps int
+	err      error
+}
+
+func newMatcher(tokens []token.Token, input string, groups int) (*matcher, error) {
+	prog, cost := compile(tokens)
+	points := len(prog) * (len(input) + 1)
+	if points > maxPoints {
+		return nil, ErrStepLimit
+	}
+	return &matcher{
+		prog:     prog,
+		input:    input,
+		caps:     newCaps(groups),
+		memo:     newMemo(0, len(prog), 0, len(input)+1),
+		refs:     map[int]ref{},
+		pathOnly: hasBackref(tokens),
+		maxSteps: max(minSteps, stepsPerPoint*cost*(len(input)+1)),
+	}, nil
+}
+
+/*
+run runs the program from instruction pc at pos in a frame, and
+returns the position the first path to reach opMatch ends at.
+A path reaching a join already in the memo stops there, as the same
+NFA state at the same position is only followed once by the NFA
+search: either the paths from it already failed, or it is on the
+path, through copies of a repetition matching nothing. Matches thus
+follow the same paths as the NFA ones, and repetitions matching
+nothing cannot loop forever. For a body, a join on the path of an
+earlier match goes on where that match ended (see success).
+With pathOnly, the paths are all followed, but a loop reached again
+at the same position on the path, after an iteration matching nothing,
+leaves the repetition, as Perl does: that iteration keeps its captures.
+ex.: (a*)+\1 matches "aa" with group 1 at [2, 2]
+On failure, the stack is back to how it was. Sets err and fails once
+the step budget is spent.
+*/
+func (m *matcher) run(pc int, pos int, f frame) (int, bool) {
+	base := len(m.stack)
+	for {
+		m.steps++
+		if m.steps > m.maxSteps {
+			m.err = ErrStepLimit
+			return 0, false
+		}
+
+		in := &m.prog[pc]
+		matched := false
+		if m.pathOnly && in.loop {
+			key := f.memo.key(pc, pos)
+			if f.memo.has(key) {
+				pc = in.after
+				continue
+			}
+			f.memo.add(key)
+			m.stack = append(m.stack, entry{markEntry, pc, pos})
+		} else if !m.pathOnly && in.join {
+			key := f.memo.key(pc, pos)
+			if f.memo.has(key) {
+				if r, ok := m.refs[key]; ok && f.body {
+					m.replay(r)
+					return m.succeed(base, f, r.success.end)
+				}
+				goto fail
+			}
+			f.memo.add(key)
+			if f.body {
+				m.stack = append(m.stack, entry{markEntry, pc, pos})
+			}
+		}
+
+		switch in.op {
+		case opChar:
+			if pos < len(m.input) && m.input[pos] == in.ch {
+				pc, pos, matched = pc+1, pos+1, true
+			}
+		case opRanges:
+			if r, size := token.DecodeRune(m.input, pos); size > 0 && inRanges(in.ranges, r) {
+				pc, pos, matched = pc+1, pos+size, true
+			}
+		case opSplit:
+			for i := len(in.next) - 1; i > 0; i-- {
+				m.stack = append(m.stack, entry{tryEntry, in.next[i], pos})
+			}
+			pc, matched = in.next[0], true
+		case opAssert:
+			if in.assertion.Holds(m.input, pos) {
+				pc, matched = pc+1, true
+			}
+		case opSave:
+			m.stack = append(m.stack, entry{restoreEntry, in.slot, m.caps[in.slot]})
+			m.caps[in.slot] = pos
+			pc, matched = pc+1, true
+		case opBackref:
+			start, end := m.caps[in.slot], m.caps[in.slot+1]
+			if start >= 0 && end >= 0 {
+				if next, ok := matchText(m.input, pos, m.input[start:end], in.fold); ok {
+					pc, pos, matched = pc+1, next, true
+				}
+			}
+		case opAtomic:
+			if end, ok := m.run(pc+1, pos, frame{target: -1, memo: m.memo, body: true}); ok {
+				pc, pos, matched = in.after, end, true
+			}
+		case opLook:
+			if m.lookaround(pc, pos) {
+				pc, matched = in.after, true
+			}
+		case opMatch:
+			if f.target < 0 || pos == f.target {
+				return m.succeed(base, f, pos)
+			}
+		}
+		if m.err != nil {
+			return 0, false
+		}
+		if matched {
+			continue
+		}
+
+	fail:
+		for {
+			if len(m.stack) == base {
+				return 0, false
+			}
+			e := m.stack[len(m.stack)-1]
+			m.stack = m.stack[:len(m.stack)-1]
+			switch e.kind {
+			case restoreEntry:
+				m.caps[e.a] = e.b
+			case markEntry:
+				if m.pathOnly {
+					f.memo.remove(f.memo.key(e.a, e.b))
+				}
+			case tryEntry:
+				pc, pos = e.a, e.b
+				break fail
+			}
+		}
+	}
+}
+
+/*
+succeed ends a frame that matched at end. The body of an atomic group
+or a lookaround never matches another way: the paths left to try are
+dropped, and the restores kept, for the captures to be undone if the
+rest of the match fails. The points on the path of a body are recorded
+as reaching this success.
+*/
+func (m *matcher) succeed(base int, f frame, end int) (int, bool) {
+	if f.target < 0 && !f.body {
+		return end, true
+	}
+
+	var s *success
+	if f.body && !m.pathOnly {
+		s = &success{end: end, caps: slices.Clone(m.caps)}
+	}
+	kept := base
+	for _, e := range m.stack[base:] {
+		switch e.kind {
+		case restoreEntry:
+			m.stack[kept] = e
+			kept++
+			if s != nil {
+				s.slots = append(s.slots, e.a)
+			}
+		case markEntry:
+			if s != nil {
+				m.refs[f.memo.key(e.a, e.b)] = ref{s, len(s.slots)}
+			} else if m.pathOnly {
+				f.memo.remove(f.memo.key(e.a, e.b))
+			}
+		}
+	}
+	m.stack = m.stack[:kept]
+	return end, true
+}
+
+// replay writes the capture slots of a success from a point on its path, as a path reaching it would
+func (m *matcher) replay(r ref) {
+	for _, slot := range r.success.slots[r.from:] {
+		m.stack = append(m.stack, entry{restoreEntry, slot, m.caps[slot]})
+		m.caps[slot] = r.success.caps[slot]
+	}
+}
+
+/*
+lookaround reports whether the lookaround at pc holds at pos, its body
+matching:
+- for a lookahead, starting at pos
+- for a lookbehind, ending at pos, starting from the closest position
+that the width of the body allows, in a memo of its own
+It keeps the captures of the body unless negated.
+*/
+func (m *matcher) lookaround(pc int, pos int) bool {
+	in := &m.prog[pc]
+	base := len(m.stack)
+	matched := false
+	if !in.behind {
+		_, matched = m.run(pc+1, pos, frame{target: -1, memo: m.memo, body: true})
+	} else {
+		// the body starts at most maxWidth before pos and matches at most maxWidth
+		width := 2*in.maxWidth + 1
+		f := frame{target: pos, memo: newMemo(pc+1, in.after-pc-1, pos-in.maxWidth, width)}
+		for w := in.minWidth; w <= in.maxWidth && w <= pos && !matched; w++ {
+			_, matched = m.run(pc+1, pos-w, f)
+		}
+	}
+	if m.err != nil {
+		return false
+	}
+
+	if matched && in.negated {
+		for len(m.stack) > base {
+			e := m.stack[len(m.stack)-1]
+			m.stack = m.stack[:len(m.stack)-1]
+			m.caps[e.a] = e.b
+		}
+	}
+	return matched != in.negated
+}
+
+/*
+Match reports whether the whole input matches the token tree,
+groups being the number of capturing groups in it.
+Fails with ErrStepLimit when the match takes too many steps.
+*/
+func Match(tokens []token.Token, input string, groups int) (bool, error) {
+	m, err := newMatcher(tokens, input, groups)
+	if err != nil {
+		return false, err
+	}
+	_, ok := m.run(0, 0, frame{target: len(input), memo: m.memo})
+	return ok, m.err
+}
+
+/*
+FindSubmatchIndex searches the input for the leftmost match of the token
+tree and returns the [start, end) offsets of the match followed by the
+ones of its groups capture groups, or nil if there is no match, like the
+NFA search (see state.FindSubmatchIndex) does, starting only at rune
+boundaries. groups must be the number of capturing groups in the tree.
+The points where the paths failed from a start position are not tried
+again from the next ones (see matcher.run).
+Fails with ErrStepLimit when the search takes too many steps.
+*/
+func FindSubmatchIndex(tokens []token.Token, input string, groups int) ([]int, error) {
+	m, err := newMatcher(tokens, input, groups)
+	if err != nil {
+		return nil, err
+	}
+	for start := 0; ; {
+		m.caps[0] = start
+		end, ok := m.run(0, start, frame{target: -1, memo: m.memo})
+		if m.err != nil {
+			return nil, m.err
+		}
+		if ok {
+			m.caps[1] = end
+			return m.caps, nil
+		}
+		if start == len(input) {
+			return nil, nil
+		}
+		_, width := utf8.DecodeRuneInString(input[start:])
+		start += width
+	}
+}
+
+// inRanges reports whether r is in one of the sorted and merged ranges
+func inRanges(ranges []token.BracketPayload, r rune) bool {
+	i := sort.Search(len(ranges), func(i int) bool {
+		return ranges[i].End >= r
+	})
+	return i < len(ranges) && ranges[i].Begin <= r
+}
+
+/*
+matchText reports whether the input continues with text at pos, and
+returns the position right after it. With fold set, characters match
+regardless of their case, so the match can differ in length from text.
+*/
+func matchText(input string, pos int, text string, fold bool) (int, bool) {
+	if !fold {
+		return pos + len(text), strings.HasPrefix(input[pos:], text)
+	}
+	for _, r := range text {
+		other, size := token.DecodeRune(input, pos)
+		if size < 0 || !equalFold(r, other) {
+			return pos, false
+		}
+		pos += size
+	}
+	return pos, true
+}
+
+// equalFold reports whether a and b are the same character under simple case folding
+func equalFold(a rune, b rune) bool {
+	if a == b {
+		return true
+	}
+	for other := unicode.SimpleFold(a); other != a; other = unicode.SimpleFold(other) {
+		if other == b {
+			return true
+		}
+	}
+	return false
+}
+
+// newCaps returns capture slots for groups capturing groups, none of them set
+func newCaps(groups int) []int {
+	caps := make([]int, 2*(groups+1))
+	for i := range caps {
+		caps[i] = -1
+	}
+	return caps
+}
+
+// hasBackref reports whether a token tree has a backreference
+func hasBackref(tokens []token.Token) bool {
+	for _, t := range tokens {
+		if t.TokenType == token_type.Backref || hasBackref(t.Children()) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,216 @@
+package backtrack
+
+import (
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rubuy-74/pstr/internal/parser"
+	"github.com/rubuy-74/pstr/internal/state_machine"
+)
+
+// TestSameAsNFA tests that the backtracker finds the same matches as the NFA
+func TestSameAsNFA(t *testing.T) {
+	tests := []struct {
+		regex  string
+		inputs []string
+	}{
+		{"abc", []string{"abc", "xxabcxx", "ab"}},
+		{"a|ab", []string{"ab", "xab"}},
+		{"(a|b)*c", []string{"zzababcab", "c", "ab"}},
+		{"(a)(b)?", []string{"ac", "ab"}},
+		{"((a)b)c", []string{"abc"}},
+		{"(a*)*", []string{"b", "aab"}},
+		{"(a*)?", []string{"b"}},
+		{"(a?)*b", []string{"aab", "ba"}},
+		{"(a|ab)(c|bcd)(d*)", []string{"abcd"}},
+		{"<.+?>", []string{"<a><b>"}},
+		{"(.*?)-(.*)", []string{"x-y-z"}},
+		{"a{2,4}?", []string{"aaaa"}},
+		{"x{2,}", []string{"xxxxx", "x"}},
+		{"^a|b$", []string{"ab", "ba", "ca"}},
+		{`(\d+)-(\d+)`, []string{"call 555-1234 now"}},
+		{"(?:(a)|b)+", []string{"ab"}},
+		{`((\w)*?)+`, []string{"bc"}},
+		{`(([ab]|\B)*?)+`, []string{"abbb "}},
+		{"(a*?)+", []string{"aa"}},
+		{"(a??b?)*", []string{"ab", "bab"}},
+	}
+
+	for _, tt := range tests {
+		ctx, err := parser.Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %v", tt.regex, err)
+		}
+		nfa, err := state_machine.ToNFA(ctx)
+		if err != nil {
+			t.Fatalf("ToNFA failed for %q: %v", tt.regex, err)
+		}
+		for _, input := range tt.inputs {
+			expected := nfa.FindSubmatchIndex(input, ctx.Groups)
+			if index, err := FindSubmatchIndex(ctx.Tokens, input, ctx.Groups); err != nil || !slices.Equal(index, expected) {
+				t.Errorf("FindSubmatchIndex(%q) on %q = %v, %v, expected %v", input, tt.regex, index, err, expected)
+			}
+			expectedMatch := nfa.Check(input)
+			if match, err := Match(ctx.Tokens, input, ctx.Groups); err != nil || match != expectedMatch {
+				t.Errorf("Match(%q) on %q = %v, %v, expected %v", input, tt.regex, match, err, expectedMatch)
+			}
+		}
+	}
+}
+
+// TestAtomic tests that atomic groups and possessive quantifiers never give back what they matched
+func TestAtomic(t *testing.T) {
+	tests := []struct {
+		regex    string
+		input    string
+		expected []int
+	}{
+		{"a*+a", "aaa", nil},
+		{"a*a", "aaa", []int{0, 3}},
+		{"a++b", "aaab", []int{0, 4}},
+		{"(?>ab|a)c", "abc", []int{0, 3}},
+		{"(?>a|ab)c", "abc", nil},
+		{"(?>a|ab)c", "ac", []int{0, 2}},
+		{`"[^"]*+"`, `say "hi" now`, []int{4, 8}},
+		{"x?+x", "x", nil},
+		{"(?>(a+))b", "aab", []int{0, 3, 0, 2}},
+		{"(?>(a)|b)c|(a)b", "ab", []int{0, 2, -1, -1, 0, 1}},
+		{"(?>a*)*b", "aaab", []int{0, 4}},
+		{"(?:(?>(a)|b))*c", "abac", []int{0, 4, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.regex+"_"+tt.input, func(t *testing.T) {
+			ctx, err := parser.Parse(tt.regex)
+			if err != nil {
+				t.Fatalf("Parse failed for %q: %v", tt.regex, err)
+			}
+
+			if index, err := FindSubmatchIndex(ctx.Tokens, tt.input, ctx.Groups); err != nil || !slices.Equal(index, tt.expected) {
+				t.Errorf("FindSubmatchIndex(%q) on %q = %v, %v, expected %v", tt.input, tt.regex, index, err, tt.expected)
+			}
+		})
+	}
+}
+
+// TestBackref tests that backreferences match the text last captured by their group
+func TestBackref(t *testing.T) {
+	tests := []struct {
+		regex    string
+		input    string
+		expected []int
+	}{
+		{`(\w+) \1`, "say bye bye now", []int{4, 11, 4, 7}},
+		{`(a|b)*\1`, "abb", []int{0, 3, 1, 2}},
+		{`(a)|\1b`, "b", nil},
+		{`(?:(a)|b)\1`, "baa", []int{1, 3, 1, 2}},
+		{`(?<c>.)\k<c>{2}`, "xyyyz", []int{1, 4, 1, 2}},
+		{`(a*)\1$`, "aaaa", []int{0, 4, 0, 2}},
+		{`(?i)(ab)\1`, "xabAB", []int{1, 5, 1, 3}},
+		{`(ab)\1`, "xabAB", nil},
+		{`(a*)+\1`, "aa", []int{0, 2, 2, 2}},
+		{`(a*)*\1b`, "aab", []int{0, 3, 2, 2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.regex+"_"+tt.input, func(t *testing.T) {
+			ctx, err := parser.Parse(tt.regex)
+			if err != nil {
+				t.Fatalf("Parse failed for %q: %v", tt.regex, err)
+			}
+
+			if index, err := FindSubmatchIndex(ctx.Tokens, tt.input, ctx.Groups); err != nil || !slices.Equal(index, tt.expected) {
+				t.Errorf("FindSubmatchIndex(%q) on %q = %v, %v, expected %v", tt.input, tt.regex, index, err, tt.expected)
+			}
+		})
+	}
+}
+
+// TestLookaround tests lookaheads and lookbehinds
+func TestLookaround(t *testing.T) {
+	tests := []struct {
+		regex    string
+		input    string
+		expected []int
+	}{
+		{"foo(?=bar)", "foobaz foobar", []int{7, 10}},
+		{"foo(?!bar)", "foobar foobaz", []int{7, 10}},
+		{"(?<=\\$)\\d+", "cost: 5 or $42", []int{12, 14}},
+		{"(?<!\\$|\\d)\\d+", "$42 or 17", []int{7, 9}},
+		{"(?<=ab|c)d", "abd", []int{2, 3}},
+		{"(?<=^a)b", "ab ab", []int{1, 2}},
+		{"(?=(a+))a", "aaa", []int{0, 1, 0, 3}},
+		{"(?!(a))b", "b", []int{0, 1, -1, -1}},
+		{"(?=a|ab)ab", "ab", []int{0, 2}},
+		{"a(?=b)*c", "ac", []int{0, 2}},
+		{"(?=(a+))ac", "aaac", []int{2, 4, 2, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.regex+"_"+tt.input, func(t *testing.T) {
+			ctx, err := parser.Parse(tt.regex)
+			if err != nil {
+				t.Fatalf("Parse failed for %q: %v", tt.regex, err)
+			}
+
+			if index, err := FindSubmatchIndex(ctx.Tokens, tt.input, ctx.Groups); err != nil || !slices.Equal(index, tt.expected) {
+				t.Errorf("FindSubmatchIndex(%q) on %q = %v, %v, expected %v", tt.input, tt.regex, index, err, tt.expected)
+			}
+		})
+	}
+}
+
+// TestLongInput tests that matches as long as the input do not grow the Go stack
+func TestLongInput(t *testing.T) {
+	input := strings.Repeat("a", 300_000)
+	tests := []struct {
+		regex    string
+		input    string
+		expected []int
+	}{
+		{"(?=a)a*$", input, []int{0, len(input)}},
+		{"(a|b)*", input, []int{0, len(input), len(input) - 1, len(input)}},
+		{"(?>a*)*b", input, nil},
+		{"(?:a(?=a))*", input, []int{0, len(input) - 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.regex, func(t *testing.T) {
+			ctx, err := parser.Parse(tt.regex)
+			if err != nil {
+				t.Fatalf("Parse failed for %q: %v", tt.regex, err)
+			}
+
+			if index, err := FindSubmatchIndex(ctx.Tokens, tt.input, ctx.Groups); err != nil || !slices.Equal(index, tt.expected) {
+				t.Errorf("FindSubmatchIndex on %q = %v, %v, expected %v", tt.regex, index, err, tt.expected)
+			}
+		})
+	}
+}
+
+// TestStepLimit tests that the backreferences taking more steps than the input allows fail
+func TestStepLimit(t *testing.T) {
+	tests := []struct {
+		regex string
+		input string
+	}{
+		{`(\w+)\s\1`, strings.Repeat("a", 4000)},
+		{`(a|b)*c\1?`, strings.Repeat("ab", 2000)},
+		{`(a*)*\1b`, strings.Repeat("a", 4000)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.regex, func(t *testing.T) {
+			ctx, err := parser.Parse(tt.regex)
+			if err != nil {
+				t.Fatalf("Parse failed for %q: %v", tt.regex, err)
+			}
+
+			if _, err := FindSubmatchIndex(ctx.Tokens, tt.input, ctx.Groups); !errors.Is(err, ErrStepLimit) {
+				t.Errorf("FindSubmatchIndex on %q failed with %v, expected %v", tt.regex, err, ErrStepLimit)
+			}
+		})
+	}
+}
@@ -0,0 +1,512 @@
+package dfa
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"unicode/utf8"
+
+	"github.com/rubuy-74/pstr/internal/models/state"
+)
+
+// DefaultMaxStates is the state limit of each automaton of a DFA when none is given
+const DefaultMaxStates = 4096
+
+// ErrStateLimit is returned when a DFA would need more states than its limit
+var ErrStateLimit = errors.New("the DFA exceeds its state limit")
+
+// dead is the state of an automaton that never leads to a match
+const dead = 0
+
+/*
+automaton is a DFA stored as a dense transition table, with a column
+per byte class of its NFA (see state.ByteClasses).
+- next holds the state reached from state s on a byte of class c at
+index s*stride+c, dead when no match can follow
+- accept tells whether a state matches before the end of the input,
+only needed by a search, acceptEnd whether it matches at the end of it,
+where "$" holds
+- start is the state at the start of the input, startMid the state
+for a search starting later, where "^" does not hold; the reverse
+automaton reads the input backwards, so its start is at the end of it
+*/
+type automaton struct {
+	classes   *state.ByteClasses
+	stride    int
+	next      []uint32
+	accept    []bool
+	acceptEnd []bool
+	start     uint32
+	startMid  uint32
+}
+
+/*
+DFA is a deterministic automaton built from an NFA by subset
+construction: each of its states stands for the set of NFA states the
+NFA simulation can be in, so matching only takes one table lookup per
+byte of the input. It holds three automata, all minimized (see minimize):
+- whole, for Check, built from the plain sets of NFA states
+- search, for FindIndex, built from the NFA states in priority order
+with a match starting at every rune boundary (see unanchored), dropping the
+ones after a final state, as the NFA search does, so the match found
+ends where the leftmost-first one does (see state.FindSubmatchIndex)
+- reverse, for FindIndex, built from the reversed NFA (see reverse),
+finding where that match starts by reading the input backwards from its end
+- built is the number of states of the automata before minimization
+*/
+type DFA struct {
+	whole   *automaton
+	search  *automaton
+	reverse *automaton
+	built   int
+}
+
+/*
+Compile builds the minimal DFA of the NFA starting at nfa, with at most
+maxStates states in each of its automata before minimization, or
+DefaultMaxStates if maxStates is not positive.
+Fails with ErrStateLimit when the DFA would need more states, which can
+grow exponentially with the size of the NFA, and on assertions other
+than the start and the end of the text, which depend on the characters
+around a position.
+*/
+func Compile(nfa *state.State, maxStates int) (*DFA, error) {
+	if maxStates <= 0 {
+		maxStates = DefaultMaxStates
+	}
+	forward, backward := unanchored(nfa), reverse(nfa)
+	ids, err := numberStates(forward)
+	if err != nil {
+		return nil, err
+	}
+	reverseIDs, err := numberStates(backward)
+	if err != nil {
+		return nil, err
+	}
+
+	whole, err := newBuilder(subsets{ids: ids}, nfa.Classes, maxStates, false).build(nfa)
+	if err != nil {
+		return nil, err
+	}
+	search, err := newBuilder(subsets{ids: ids, leftmostFirst: true}, nfa.Classes, maxStates, true).build(forward)
+	if err != nil {
+		return nil, err
+	}
+	reversed, err := newBuilder(subsets{ids: reverseIDs}, nfa.Classes, maxStates, true).build(backward)
+	if err != nil {
+		return nil, err
+	}
+	return &DFA{
+		whole:   minimize(whole),
+		search:  minimize(search),
+		reverse: minimize(reversed),
+		built:   len(whole.acceptEnd) + len(search.acceptEnd) + len(reversed.acceptEnd),
+	}, nil
+}
+
+/*
+unanchored returns the initial state of an NFA that matches like the
+one starting at nfa from every rune boundary of the input, as if the
+regex started with (?s:.)*?: a loop state tries nfa first and, with a
+lower priority, consumes a whole rune to come back to itself. The
+search then reads the input once, from its start, instead of once per
+position. A lead byte is followed by the continuation bytes of its
+sequence, told apart by the byte classes (see token.ByteClassesOf),
+and an invalid byte is a rune of its own.
+*/
+func unanchored(nfa *state.State) *state.State {
+	classes := nfa.Classes
+	loop := &state.State{Initial: true, Classes: classes}
+	anyRune := &state.State{Transitions: map[uint8][]*state.State{}}
+	// tails[n] consumes the n continuation bytes left in a sequence
+	tails := []*state.State{loop}
+	for n := 1; n < utf8.UTFMax; n++ {
+		tail := &state.State{Transitions: map[uint8][]*state.State{}}
+		for b := 0x80; b <= 0xBF; b++ {
+			tail.Transitions[classes.Class(byte(b))] = []*state.State{tails[n-1]}
+		}
+		tails = append(tails, tail)
+	}
+	for b := 0; b < 256; b++ {
+		next := loop
+		switch {
+		case b >= 0xF0:
+			next = tails[3]
+		case b >= 0xE0:
+			next = tails[2]
+		case b >= 0xC0:
+			next = tails[1]
+		}
+		anyRune.Transitions[classes.Class(byte(b))] = []*state.State{next}
+	}
+	anyRune.Transitions[classes.Invalid()] = []*state.State{loop}
+	loop.Epsilon = []*state.State{nfa, anyRune}
+	return loop
+}
+
+/*
+numberStates gives every state reachable from nfa a distinct number,
+used to identify sets of states, and fails on the assertions the DFA
+does not support.
+*/
+func numberStates(nfa *state.State) (map[*state.State]int, error) {
+	ids := map[*state.State]int{nfa: 0}
+	stack := []*state.State{nfa}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		switch s.Assertion {
+		case state.NoAssertion, state.TextStart, state.TextEnd:
+		default:
+			return nil, fmt.Errorf("the DFA engine only supports the start and end of text assertions, not assertion %d", s.Assertion)
+		}
+
+		next := append([]*state.State{}, s.Epsilon...)
+		for _, targets := range s.Transitions {
+			next = append(next, targets...)
+		}
+		for _, other := range next {
+			if _, ok := ids[other]; !ok {
+				ids[other] = len(ids)
+				stack = append(stack, other)
+			}
+		}
+	}
+	return ids, nil
+}
+
+/*
+subsets computes the sets of NFA states that stand for DFA states.
+- ids numbers the NFA states (see numberStates)
+- leftmostFirst keeps the NFA states in priority order, for a search
+*/
+type subsets struct {
+	ids           map[*state.State]int
+	leftmostFirst bool
+}
+
+/*
+builder runs the subset construction of an automaton.
+- search tells whether the automaton is run by a search, which needs
+to know where it accepts before the end of the input, and to start
+away from the start of the input
+- sets holds the NFA states of each DFA state, by number
+- states maps the key of a set of NFA states to its DFA state
+- reached maps the key of the NFA states a transition leads to, before
+their epsilon closure, to its DFA state, so that the closure of the
+same states is only computed once
+*/
+type builder struct {
+	subsets
+	search    bool
+	maxStates int
+	sets      [][]*state.State
+	states    map[string]uint32
+	reached   map[string]uint32
+	auto      *automaton
+}
+
+func newBuilder(ss subsets, classes *state.ByteClasses, maxStates int, search bool) *builder {
+	b := &builder{
+		subsets:   ss,
+		search:    search,
+		maxStates: maxStates,
+		sets:      [][]*state.State{},
+		states:    map[string]uint32{},
+		reached:   map[string]uint32{},
+		auto:      &automaton{classes: classes, stride: classes.Len()},
+	}
+	// the empty set is the dead state
+	b.add(nil, false)
+	return b
+}
+
+/*
+build runs the subset construction from the initial state of the NFA:
+every DFA state that is reached gets its transition on each byte class,
+until no new state appears.
+*/
+func (b *builder) build(nfa *state.State) (*automaton, error) {
+	var err error
+	if b.auto.start, err = b.add(b.closure([]*state.State{nfa}, true, false), true); err != nil {
+		return nil, err
+	}
+	if !b.search {
+		// Check always starts at the start of the input
+		b.auto.startMid = b.auto.start
+	} else if b.auto.startMid, err = b.add(b.closure([]*state.State{nfa}, false, false), false); err != nil {
+		return nil, err
+	}
+
+	for current := 1; current < len(b.sets); current++ {
+		for class, targets := range b.moves(b.sets[current], b.auto.stride) {
+			if len(targets) == 0 {
+				continue
+			}
+			key := b.key(targets, false)
+			next, ok := b.reached[key]
+			if !ok {
+				if next, err = b.add(b.closure(targets, false, false), false); err != nil {
+					return nil, err
+				}
+				b.reached[key] = next
+			}
+			b.auto.next[current*b.auto.stride+class] = next
+		}
+	}
+	return b.auto, nil
+}
+
+/*
+add returns the DFA state of a set of NFA states, creating it if it
+is new (see describe for atStart).
+*/
+func (b *builder) add(set []*state.State, atStart bool) (uint32, error) {
+	key, acceptEnd := b.describe(set, atStart)
+	if id, ok := b.states[key]; ok {
+		return id, nil
+	}
+	// the dead state does not count against the limit
+	if len(b.sets) > b.maxStates {
+		return dead, ErrStateLimit
+	}
+
+	id := uint32(len(b.sets))
+	b.states[key] = id
+	b.sets = append(b.sets, set)
+	b.auto.next = append(b.auto.next, make([]uint32, b.auto.stride)...)
+	b.auto.accept = append(b.auto.accept, hasFinal(set) && b.search)
+	b.auto.acceptEnd = append(b.auto.acceptEnd, acceptEnd)
+	return id, nil
+}
+
+/*
+describe returns the key of the DFA state of a set of NFA states and
+whether it matches at the end of the input. atStart tells whether "^"
+holds for the state, which only changes it when the state then matches
+at the end of the input, as in "$^": it is then kept apart from the
+state with the same NFA states.
+*/
+func (ss subsets) describe(set []*state.State, atStart bool) (string, bool) {
+	acceptEnd := hasFinal(ss.closure(set, atStart, true))
+	apart := atStart && acceptEnd != hasFinal(ss.closure(set, false, true))
+	return ss.key(set, apart), acceptEnd
+}
+
+/*
+step returns the set of NFA states reached from the given ones by
+consuming a byte of the given class, followed by their epsilon closure.
+*/
+func (ss subsets) step(set []*state.State, class uint8) []*state.State {
+	targets := []*state.State{}
+	for _, s := range set {
+		// a final state ends the search for the states after it,
+		// which have a lower priority
+		if s.Final && ss.leftmostFirst {
+			break
+		}
+		targets = append(targets, s.Transitions[class]...)
+	}
+	if len(targets) == 0 {
+		return nil
+	}
+	return ss.closure(targets, false, false)
+}
+
+/*
+moves returns the NFA states reached from the given ones by consuming
+a byte of each class, before their epsilon closure (see step), going
+through the transitions of each state once.
+*/
+func (ss subsets) moves(set []*state.State, stride int) [][]*state.State {
+	moves := make([][]*state.State, stride)
+	for _, s := range set {
+		if s.Final && ss.leftmostFirst {
+			break
+		}
+		for class, targets := range s.Transitions {
+			moves[class] = append(moves[class], targets...)
+		}
+	}
+	return moves
+}
+
+/*
+key identifies a set of NFA states by their numbers, in priority order
+for a search and sorted otherwise, so that the same set reached in
+another order is the same DFA state, and by whether it is kept apart.
+*/
+func (ss subsets) key(set []*state.State, apart bool) string {
+	if len(set) == 0 {
+		return ""
+	}
+	numbers := make([]int, len(set))
+	for i, s := range set {
+		numbers[i] = ss.ids[s]
+	}
+	if !ss.leftmostFirst {
+		slices.Sort(numbers)
+	}
+
+	key := []byte{0}
+	if apart {
+		key[0] = 1
+	}
+	for _, number := range numbers {
+		key = binary.AppendUvarint(key, uint64(number))
+	}
+	return string(key)
+}
+
+/*
+closure returns the states reachable from the given ones through epsilon
+transitions, in priority order, keeping only the ones that matter to the
+DFA: the states with byte transitions, the final states and, away from
+the end of the input, the states waiting for it to assert "$".
+atStart and atEnd tell whether "^" and "$" hold.
+*/
+func (ss subsets) closure(states []*state.State, atStart bool, atEnd bool) []*state.State {
+	closure := []*state.State{}
+	visited := map[*state.State]bool{}
+	stack := []*state.State{}
+	for i := len(states) - 1; i >= 0; i-- {
+		stack = append(stack, states[i])
+	}
+
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if visited[s] {
+			continue
+		}
+		visited[s] = true
+
+		holds := s.Assertion == state.NoAssertion ||
+			s.Assertion == state.TextStart && atStart ||
+			s.Assertion == state.TextEnd && atEnd
+		if len(s.Transitions) > 0 || s.Final || s.Assertion == state.TextEnd && !atEnd {
+			closure = append(closure, s)
+		}
+		if !holds {
+			continue
+		}
+		for i := len(s.Epsilon) - 1; i >= 0; i-- {
+			stack = append(stack, s.Epsilon[i])
+		}
+	}
+	return closure
+}
+
+// hasFinal reports whether one of the states is final
+func hasFinal(states []*state.State) bool {
+	for _, s := range states {
+		if s.Final {
+			return true
+		}
+	}
+	return false
+}
+
+// Check reports whether the whole input is matched by the DFA
+func (d *DFA) Check(input string) bool {
+	current := d.whole.start
+	for pos := 0; pos < len(input); pos++ {
+		current = d.whole.next[int(current)*d.whole.stride+int(d.whole.classes.ClassAt(input, pos))]
+		if current == dead {
+			return false
+		}
+	}
+	return d.whole.acceptEnd[current]
+}
+
+/*
+FindIndex searches the input for the leftmost match of the DFA and
+returns its [start, end) offsets, or nil if there is none. The match
+is the one the NFA search finds (see state.FindSubmatchIndex), found in
+time linear in the length of the input:
+- the search automaton runs from the start of the input until it dies,
+the last position where it accepted being the end of the match
+- the reverse automaton runs from there back towards the start of the
+input until it dies, the first position where it accepted being the
+start of the match, the leftmost one from which the regex matches up
+to the end
+*/
+func (d *DFA) FindIndex(input string) []int {
+	end := -1
+	current := d.search.start
+	for pos := 0; ; pos++ {
+		if pos == len(input) {
+			if d.search.acceptEnd[current] {
+				end = pos
+			}
+			break
+		}
+		if d.search.accept[current] {
+			end = pos
+		}
+		current = d.search.next[int(current)*d.search.stride+int(d.search.classes.ClassAt(input, pos))]
+		if current == dead {
+			break
+		}
+	}
+	if end < 0 {
+		return nil
+	}
+
+	start := end
+	current = d.reverse.startMid
+	if end == len(input) {
+		current = d.reverse.start
+	}
+	for pos := end; ; pos-- {
+		if pos == 0 {
+			if d.reverse.acceptEnd[current] {
+				start = pos
+			}
+			break
+		}
+		if d.reverse.accept[current] {
+			start = pos
+		}
+		current = d.reverse.next[int(current)*d.reverse.stride+int(d.reverse.classes.ClassAt(input, pos-1))]
+		if current == dead {
+			break
+		}
+	}
+	return []int{start, end}
+}
+
+/*
+Find searches the input for the leftmost match of the DFA (see FindIndex)
+and returns the matched text, and whether there was one.
+*/
+func (d *DFA) Find(input string) (string, bool) {
+	match := d.FindIndex(input)
+	if match == nil {
+		return "", false
+	}
+	return input[match[0]:match[1]], true
+}
+
+// States returns the number of states of the DFA, the dead state of each of its automata included
+func (d *DFA) States() int {
+	return len(d.whole.acceptEnd) + len(d.search.acceptEnd) + len(d.reverse.acceptEnd)
+}
+
+// BuiltStates returns the number of states the DFA had before it was minimized (see States)
+func (d *DFA) BuiltStates() int {
+	return d.built
+}
+
+/*
+Equivalent reports whether two DFAs match the same inputs as a whole
+(see Check). Their minimized automata are then the same up to the
+numbering of their states, so comparing them tells whether two regexes
+are equivalent, however differently they are written.
+ex.: (a|b)*c and [ab]*c are equivalent, a+ and a* are not
+*/
+func (d *DFA) Equivalent(other *DFA) bool {
+	return equivalent(d.whole, other.whole)
+}
@@ -0,0 +1,226 @@
+package dfa
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/rubuy-74/pstr/internal/models/state"
+	"github.com/rubuy-74/pstr/internal/parser"
+	"github.com/rubuy-74/pstr/internal/state_machine"
+)
+
+func compileNFA(t testing.TB, regex string) *state.State {
+	t.Helper()
+	ctx, err := parser.Parse(regex)
+	if err != nil {
+		t.Fatalf("Parse failed for %q: %v", regex, err)
+	}
+	nfa, err := state_machine.ToNFA(ctx)
+	if err != nil {
+		t.Fatalf("ToNFA failed for %q: %v", regex, err)
+	}
+	return nfa
+}
+
+// sameAsNFATests are regexes and inputs on which the DFAs must match like the NFA
+var sameAsNFATests = []struct {
+	regex  string
+	inputs []string
+}{
+	{"abc", []string{"abc", "xxabcxx", "ab", ""}},
+	{"a|ab", []string{"a", "ab", "xab"}},
+	{"ab|a", []string{"ab", "xab"}},
+	{"(a|b)*c", []string{"zzababcab", "c", "ab", "abc"}},
+	{"a*", []string{"", "baaa", "aaa"}},
+	{"a+?", []string{"aaa"}},
+	{"<.+?>", []string{"<a><b>", "<>"}},
+	{"<.+>", []string{"<a><b>"}},
+	{"(a*)*b", []string{"aab", "b", "aaa"}},
+	{"x{2,4}", []string{"xxxxx", "x"}},
+	{"^a|b$", []string{"ab", "ba", "ca", "a", ""}},
+	{"^$", []string{"", "a"}},
+	{"$^", []string{"", "a"}},
+	{"a^b", []string{"ab"}},
+	{`\d+-\d+`, []string{"call 555-1234 now", "555-"}},
+	{"[à-ü]+", []string{"niño", "nino"}},
+	{"(?i)straße", []string{"STRAẞE", "strasse"}},
+	{"(?s).+", []string{"a\nb"}},
+	{`\Aab\z`, []string{"ab", "abab"}},
+	{"a*b", []string{"xaaab", "aaac", "aab aab"}},
+	{"b|ab*c", []string{"abbc", "abbb", "xabcb"}},
+	{"(?:^|x)a", []string{"a", "xa", "bxa", "ba"}},
+	{"a$|b", []string{"ab", "ba", "aa"}},
+	{"x*$", []string{"axx", "a", ""}},
+	{"b?", []string{"", "ab"}},
+}
+
+// TestSameAsNFA tests that the DFA checks and finds the same matches as the NFA
+func TestSameAsNFA(t *testing.T) {
+	for _, tt := range sameAsNFATests {
+		nfa := compileNFA(t, tt.regex)
+		d, err := Compile(nfa, 0)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", tt.regex, err)
+		}
+
+		for _, input := range tt.inputs {
+			if result, expected := d.Check(input), nfa.Check(input); result != expected {
+				t.Errorf("Check(%q) on %q = %v, expected %v", input, tt.regex, result, expected)
+			}
+			if index, expected := d.FindIndex(input), nfa.FindIndex(input); !slices.Equal(index, expected) {
+				t.Errorf("FindIndex(%q) on %q = %v, expected %v", input, tt.regex, index, expected)
+			}
+		}
+	}
+}
+
+// TestStateLimit tests that building a DFA fails past its state limit
+func TestStateLimit(t *testing.T) {
+	// the DFA has to remember the last 11 characters
+	nfa := compileNFA(t, "(a|b)*a(a|b){10}")
+	if _, err := Compile(nfa, 100); !errors.Is(err, ErrStateLimit) {
+		t.Errorf("expected ErrStateLimit, got %v", err)
+	}
+
+	d, err := Compile(compileNFA(t, "(a|b)*abb"), 11)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	// the search needs 11 states, 3 of them for the rest of a multibyte
+	// rune, 10 once minimized, the other automata 4, and each has a dead state
+	if d.States() != 10+4+4+3 || !d.Check("babb") || d.Check("abba") {
+		t.Errorf("unexpected DFA with %d states", d.States())
+	}
+}
+
+// TestUnsupportedAssertions tests that the DFA rejects assertions depending on the characters around a position
+func TestUnsupportedAssertions(t *testing.T) {
+	tests := []string{`\bword\b`, `a\B`, "(?m)^a$", `a\Z`}
+
+	for _, regex := range tests {
+		if _, err := Compile(compileNFA(t, regex), 0); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+// TestMinimize tests that minimization merges the states no input tells apart
+func TestMinimize(t *testing.T) {
+	tests := []struct {
+		regex  string
+		states int
+	}{
+		// the states for Check, the search and the reverse search, the
+		// search having 3 for the rest of a multibyte rune before a match
+		{"abc", 5 + 8 + 5},
+		{"(a|b)*abb", 5 + 11 + 5},
+		{"(a|b)*c|[ab]*c", 3 + 6 + 3},
+		{"a*a*a*", 2 + 2 + 2},
+		{"(?:ab|ab|ab)+", 4 + 8 + 4},
+		{"a^b", 1 + 1 + 1},
+	}
+
+	for _, tt := range tests {
+		d, err := Compile(compileNFA(t, tt.regex), 0)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", tt.regex, err)
+		}
+		if d.States() != tt.states || d.BuiltStates() < d.States() {
+			t.Errorf("expected %d states for %q, got %d out of %d", tt.states, tt.regex, d.States(), d.BuiltStates())
+		}
+	}
+}
+
+// TestEquivalent tests that equivalent regexes have the same minimal DFA
+func TestEquivalent(t *testing.T) {
+	tests := []struct {
+		a        string
+		b        string
+		expected bool
+	}{
+		{"(a|b)*c", "[ab]*c", true},
+		{"a+", "aa*", true},
+		{"a+", "a*", false},
+		{"(ab)*a", "a(ba)*", true},
+		{"a$|a", "a", true},
+		{`\d{2,3}`, "[0-9][0-9][0-9]?", true},
+		{"abc", "abd", false},
+		{"(?i)a", "[Aa]", true},
+	}
+
+	for _, tt := range tests {
+		a, err := Compile(compileNFA(t, tt.a), 0)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", tt.a, err)
+		}
+		b, err := Compile(compileNFA(t, tt.b), 0)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", tt.b, err)
+		}
+		if result := a.Equivalent(b); result != tt.expected {
+			t.Errorf("Equivalent(%q, %q) = %v, expected %v", tt.a, tt.b, result, tt.expected)
+		}
+	}
+}
+
+// TestByteClassTables tests that the transition tables have a column per byte class, the invalid bytes included, instead of per byte
+func TestByteClassTables(t *testing.T) {
+	tests := []struct {
+		regex  string
+		stride int
+	}{
+		// [\x00-`], [a-z], [{-\x7f], the continuation bytes, the lead
+		// bytes of each length and the invalid bytes
+		{"[a-z]+", 8},
+		{"abc", 10},
+		{`\d+-\d+`, 10},
+	}
+
+	for _, tt := range tests {
+		d, err := Compile(compileNFA(t, tt.regex), 0)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", tt.regex, err)
+		}
+		for _, a := range []*automaton{d.whole, d.search} {
+			if a.stride != tt.stride || len(a.next) != tt.stride*len(a.acceptEnd) {
+				t.Errorf("expected %d columns for %q, got %d for %d transitions", tt.stride, tt.regex, a.stride, len(a.next))
+			}
+		}
+	}
+}
+
+// BenchmarkFindIndex tests that FindIndex takes time linear in the length of the input, matching or not, on both DFAs
+func BenchmarkFindIndex(b *testing.B) {
+	nfa := compileNFA(b, "a*b")
+	d, err := Compile(nfa, 0)
+	if err != nil {
+		b.Fatalf("Compile failed: %v", err)
+	}
+	l, err := NewLazy(nfa, 0)
+	if err != nil {
+		b.Fatalf("NewLazy failed: %v", err)
+	}
+
+	engines := []struct {
+		name      string
+		findIndex func(string) []int
+	}{
+		{"dfa", d.FindIndex},
+		{"lazy", l.FindIndex},
+	}
+	for _, engine := range engines {
+		for _, n := range []int{1000, 10000, 100000} {
+			for _, last := range []string{"b", "c"} {
+				input := strings.Repeat("a", n) + last
+				b.Run(fmt.Sprintf("%s/%d%s", engine.name, n, last), func(b *testing.B) {
+					for i := 0; i < b.N; i++ {
+						engine.findIndex(input)
+					}
+				})
+			}
+		}
+	}
+}
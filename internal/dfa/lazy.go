@@ -0,0 +1,306 @@
+package dfa
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/rubuy-74/pstr/internal/models/state"
+)
+
+/*
+Stats are the counters of the state cache of a lazy DFA, to tune its size.
+- States is the number of states in the cache
+- Hits counts the transitions that were found in the cache
+- Misses counts the transitions that had to be computed from the NFA
+- Evictions counts the states dropped from a full cache
+- Flushes counts the times the whole cache was dropped, as it thrashed
+*/
+type Stats struct {
+	States    int
+	Hits      int
+	Misses    int
+	Evictions int
+	Flushes   int
+}
+
+/*
+lazyState is a DFA state built on demand.
+- next holds the state reached on each byte class, nil while it is not
+known and deadState when no match can follow
+- evicted marks a state dropped from the cache: transitions to it are
+computed again when they are followed
+*/
+type lazyState struct {
+	key       string
+	set       []*state.State
+	accept    bool
+	acceptEnd bool
+	next      []*lazyState
+	element   *list.Element
+	evicted   bool
+}
+
+// deadState is the state of a lazy DFA that never leads to a match
+var deadState = &lazyState{}
+
+/*
+cache holds the states of a lazy automaton that were built so far,
+at most capacity of them, the least recently used one being evicted
+to make room for a new one.
+Every eviction leaves transitions to the evicted state behind, which
+keep it in memory. Once there were as many evictions as the capacity
+during a search, the states needed by the input do not fit in the
+cache, and the whole cache is flushed to release them. Evictions spread
+over many searches, each needing a few new states, do not flush it.
+*/
+type cache struct {
+	subsets
+	nfa       *state.State
+	classes   *state.ByteClasses
+	capacity  int
+	states    map[string]*lazyState
+	lru       *list.List
+	evictions int
+	start     *lazyState
+	startMid  *lazyState
+	stats     *Stats
+}
+
+func newCache(ss subsets, nfa *state.State, capacity int, stats *Stats) *cache {
+	return &cache{
+		subsets:  ss,
+		nfa:      nfa,
+		classes:  nfa.Classes,
+		capacity: capacity,
+		states:   map[string]*lazyState{},
+		lru:      list.New(),
+		stats:    stats,
+	}
+}
+
+// begin prepares the cache for a search, which counts its evictions from zero
+func (c *cache) begin() {
+	c.evictions = 0
+}
+
+// flush drops every state of the cache
+func (c *cache) flush() {
+	for element := c.lru.Front(); element != nil; element = element.Next() {
+		element.Value.(*lazyState).evicted = true
+	}
+	c.stats.States -= len(c.states)
+	c.states = map[string]*lazyState{}
+	c.lru = list.New()
+	c.evictions = 0
+	c.start, c.startMid = nil, nil
+}
+
+/*
+get returns the state of a set of NFA states, from the cache or built
+and added to it (see describe for atStart).
+*/
+func (c *cache) get(set []*state.State, atStart bool) *lazyState {
+	if len(set) == 0 {
+		return deadState
+	}
+	key, acceptEnd := c.describe(set, atStart)
+	if s, ok := c.states[key]; ok {
+		c.lru.MoveToFront(s.element)
+		return s
+	}
+
+	if len(c.states) >= c.capacity {
+		c.evict()
+	}
+	s := &lazyState{
+		key:       key,
+		set:       set,
+		accept:    hasFinal(set),
+		acceptEnd: acceptEnd,
+		next:      make([]*lazyState, c.classes.Len()),
+	}
+	s.element = c.lru.PushFront(s)
+	c.states[key] = s
+	c.stats.States++
+	return s
+}
+
+// evict drops the least recently used state, or the whole cache when it thrashes
+func (c *cache) evict() {
+	c.stats.Evictions++
+	c.evictions++
+	if c.evictions >= c.capacity {
+		c.stats.Flushes++
+		c.flush()
+		return
+	}
+
+	s := c.lru.Remove(c.lru.Back()).(*lazyState)
+	s.evicted = true
+	delete(c.states, s.key)
+	c.stats.States--
+}
+
+// initial returns the state a match starts in, atStart telling whether it is at the start of the input
+func (c *cache) initial(atStart bool) *lazyState {
+	current := &c.startMid
+	if atStart {
+		current = &c.start
+	}
+	if *current == nil || (*current).evicted {
+		*current = c.get(c.closure([]*state.State{c.nfa}, atStart, false), atStart)
+	}
+	return *current
+}
+
+// next returns the state reached from s on the byte at pos in the input, building it if it is not known
+func (c *cache) next(s *lazyState, input string, pos int) *lazyState {
+	if s == deadState {
+		return deadState
+	}
+	class := c.classes.ClassAt(input, pos)
+	if next := s.next[class]; next != nil && !next.evicted {
+		c.stats.Hits++
+		if next != deadState {
+			c.lru.MoveToFront(next.element)
+		}
+		return next
+	}
+
+	c.stats.Misses++
+	next := c.get(c.step(s.set, class), false)
+	s.next[class] = next
+	return next
+}
+
+/*
+Lazy is a DFA whose states are only built as the input needs them,
+from the NFA states they stand for (see DFA), and kept in a cache of
+bounded size, so its memory use stays bounded whatever the pattern.
+Like DFA, it holds an automaton for Check, keyed by the sorted numbers
+of its NFA states, and two for FindIndex: the search one, keyed by their
+priority order, and the reverse one, keyed by their sorted numbers.
+Matching updates the caches, which are guarded by a mutex.
+*/
+type Lazy struct {
+	mu      sync.Mutex
+	whole   *cache
+	search  *cache
+	reverse *cache
+	stats   Stats
+}
+
+/*
+NewLazy prepares the lazy DFA of the NFA starting at nfa, caching at
+most maxStates states for each of its automata, or DefaultMaxStates if
+maxStates is not positive. Fails on the assertions the DFA does not
+support (see Compile).
+*/
+func NewLazy(nfa *state.State, maxStates int) (*Lazy, error) {
+	if maxStates <= 0 {
+		maxStates = DefaultMaxStates
+	}
+	forward, backward := unanchored(nfa), reverse(nfa)
+	ids, err := numberStates(forward)
+	if err != nil {
+		return nil, err
+	}
+	reverseIDs, err := numberStates(backward)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Lazy{}
+	l.whole = newCache(subsets{ids: ids}, nfa, maxStates, &l.stats)
+	l.search = newCache(subsets{ids: ids, leftmostFirst: true}, forward, maxStates, &l.stats)
+	l.reverse = newCache(subsets{ids: reverseIDs}, backward, maxStates, &l.stats)
+	return l, nil
+}
+
+// Check reports whether the whole input is matched by the DFA
+func (l *Lazy) Check(input string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.whole.begin()
+	current := l.whole.initial(true)
+	for pos := 0; pos < len(input); pos++ {
+		current = l.whole.next(current, input, pos)
+		if current == deadState {
+			return false
+		}
+	}
+	return current.acceptEnd
+}
+
+/*
+FindIndex searches the input for the leftmost match of the DFA and
+returns its [start, end) offsets, or nil if there is none, the same
+match as the one of DFA.FindIndex, found the same way: one pass forwards
+for its end, one backwards for its start.
+*/
+func (l *Lazy) FindIndex(input string) []int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.search.begin()
+	end := -1
+	current := l.search.initial(true)
+	for pos := 0; ; pos++ {
+		if pos == len(input) {
+			if current.acceptEnd {
+				end = pos
+			}
+			break
+		}
+		if current.accept {
+			end = pos
+		}
+		current = l.search.next(current, input, pos)
+		if current == deadState {
+			break
+		}
+	}
+	if end < 0 {
+		return nil
+	}
+
+	l.reverse.begin()
+	start := end
+	current = l.reverse.initial(end == len(input))
+	for pos := end; ; pos-- {
+		if pos == 0 {
+			if current.acceptEnd {
+				start = pos
+			}
+			break
+		}
+		if current.accept {
+			start = pos
+		}
+		current = l.reverse.next(current, input, pos-1)
+		if current == deadState {
+			break
+		}
+	}
+	return []int{start, end}
+}
+
+/*
+Find searches the input for the leftmost match of the DFA (see FindIndex)
+and returns the matched text, and whether there was one.
+*/
+func (l *Lazy) Find(input string) (string, bool) {
+	match := l.FindIndex(input)
+	if match == nil {
+		return "", false
+	}
+	return input[match[0]:match[1]], true
+}
+
+// Stats returns the counters of the state caches of the DFA
+func (l *Lazy) Stats() Stats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.stats
+}
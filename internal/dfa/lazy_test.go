@@ -0,0 +1,117 @@
+package dfa
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// TestLazySameAsNFA tests that the lazy DFA checks and finds the same matches as the NFA
+func TestLazySameAsNFA(t *testing.T) {
+	for _, tt := range sameAsNFATests {
+		nfa := compileNFA(t, tt.regex)
+		// a tiny cache makes states get evicted and flushed along the way
+		for _, maxStates := range []int{0, 1, 2} {
+			l, err := NewLazy(nfa, maxStates)
+			if err != nil {
+				t.Fatalf("NewLazy failed for %q: %v", tt.regex, err)
+			}
+
+			for _, input := range tt.inputs {
+				if result, expected := l.Check(input), nfa.Check(input); result != expected {
+					t.Errorf("Check(%q) on %q with %d states = %v, expected %v", input, tt.regex, maxStates, result, expected)
+				}
+				if index, expected := l.FindIndex(input), nfa.FindIndex(input); !slices.Equal(index, expected) {
+					t.Errorf("FindIndex(%q) on %q with %d states = %v, expected %v", input, tt.regex, maxStates, index, expected)
+				}
+			}
+		}
+	}
+}
+
+// TestLazyBlowup tests that the lazy DFA matches a regex whose full DFA is too large, within its cache size
+func TestLazyBlowup(t *testing.T) {
+	regex := "(a|b)*a(a|b){20}"
+	nfa := compileNFA(t, regex)
+	if _, err := Compile(nfa, 0); err == nil {
+		t.Fatalf("expected the full DFA of %q to exceed its state limit", regex)
+	}
+
+	l, err := NewLazy(nfa, 64)
+	if err != nil {
+		t.Fatalf("NewLazy failed: %v", err)
+	}
+	// a pseudo-random mix of a and b reaches many DFA states
+	mixed := []byte{}
+	for i, x := 0, uint32(1); i < 2000; i++ {
+		x = x*1103515245 + 12345
+		mixed = append(mixed, "ab"[x>>16&1])
+	}
+	inputs := []string{
+		string(mixed),
+		string(mixed[:len(mixed)-10]) + strings.Repeat("b", 20),
+		"a" + strings.Repeat("b", 20),
+		strings.Repeat("ab", 30),
+		strings.Repeat("aab", 30),
+		"b" + strings.Repeat("b", 20),
+		strings.Repeat("b", 20),
+	}
+	for _, input := range inputs {
+		if result, expected := l.Check(input), nfa.Check(input); result != expected {
+			t.Errorf("Check(%q) = %v, expected %v", input, result, expected)
+		}
+		if index, expected := l.FindIndex(input), nfa.FindIndex(input); !slices.Equal(index, expected) {
+			t.Errorf("FindIndex(%q) = %v, expected %v", input, index, expected)
+		}
+	}
+
+	stats := l.Stats()
+	if stats.States > 3*64 {
+		t.Errorf("expected at most %d cached states, got %d", 3*64, stats.States)
+	}
+	if stats.Evictions == 0 || stats.Flushes == 0 {
+		t.Errorf("expected the cache to evict and flush states, got %+v", stats)
+	}
+}
+
+// TestLazyStats tests that the cache counts its hits and misses
+func TestLazyStats(t *testing.T) {
+	l, err := NewLazy(compileNFA(t, "[a-z]+"), 0)
+	if err != nil {
+		t.Fatalf("NewLazy failed: %v", err)
+	}
+
+	l.Check("abc")
+	first := l.Stats()
+	if first.Misses == 0 || first.States == 0 {
+		t.Errorf("expected the first check to build states, got %+v", first)
+	}
+
+	l.Check("abc")
+	second := l.Stats()
+	if second.Misses != first.Misses || second.Hits != first.Hits+3 || second.States != first.States {
+		t.Errorf("expected the second check to only hit the cache, got %+v after %+v", second, first)
+	}
+	if second.Evictions != 0 || second.Flushes != 0 {
+		t.Errorf("expected no eviction, got %+v", second)
+	}
+}
+
+// TestLazyEvictionsPerSearch tests that searches each evicting a few states do not flush the cache
+func TestLazyEvictionsPerSearch(t *testing.T) {
+	l, err := NewLazy(compileNFA(t, "ab|cd|ef|gh|ij|kl|mn|op"), 3)
+	if err != nil {
+		t.Fatalf("NewLazy failed: %v", err)
+	}
+
+	// every input needs a state of its own after its first letter
+	for _, input := range []string{"ab", "cd", "ef", "gh", "ij", "kl", "mn", "op"} {
+		if !l.Check(input) {
+			t.Errorf("expected %q to match", input)
+		}
+	}
+	stats := l.Stats()
+	if stats.Evictions < 3 || stats.Flushes != 0 {
+		t.Errorf("expected states to be evicted without a flush, got %+v", stats)
+	}
+}
@@ -0,0 +1,192 @@
+package dfa
+
+import "slices"
+
+/*
+minimize returns the smallest automaton matching like a, merging the
+states that no input tells apart, found by Hopcroft's partition
+refinement:
+- the states start split into blocks by whether they accept
+- a block is a splitter: for each byte class, the states whose
+transition on it leads into the block are told apart from the others,
+which splits every block that has states of both kinds
+- of the two halves of a split block, only the smaller one has to be
+used as a splitter later, unless the block was still waiting to be one
+The states that can never lead to a match all end up in the block of
+the dead state, which stays the state 0.
+*/
+func minimize(a *automaton) *automaton {
+	states := len(a.acceptEnd)
+
+	// previous[c][t] holds the states whose transition on class c leads to t
+	previous := make([][][]int, a.stride)
+	for c := range previous {
+		previous[c] = make([][]int, states)
+		for s := 0; s < states; s++ {
+			t := a.next[s*a.stride+c]
+			previous[c][t] = append(previous[c][t], s)
+		}
+	}
+
+	blockOf := make([]int, states)
+	blocks := [][]int{}
+	initial := map[[2]bool]int{}
+	for s := 0; s < states; s++ {
+		label := [2]bool{a.accept[s], a.acceptEnd[s]}
+		block, ok := initial[label]
+		if !ok {
+			block = len(blocks)
+			initial[label] = block
+			blocks = append(blocks, []int{})
+		}
+		blockOf[s] = block
+		blocks[block] = append(blocks[block], s)
+	}
+
+	waiting := []int{}
+	isWaiting := make([]bool, len(blocks))
+	for block := range blocks {
+		waiting = append(waiting, block)
+		isWaiting[block] = true
+	}
+
+	inSplitter := make([]bool, states)
+	counts := make([]int, states)
+	for len(waiting) > 0 {
+		splitter := slices.Clone(blocks[waiting[len(waiting)-1]])
+		isWaiting[waiting[len(waiting)-1]] = false
+		waiting = waiting[:len(waiting)-1]
+
+		for c := range previous {
+			marked := []int{}
+			for _, t := range splitter {
+				for _, s := range previous[c][t] {
+					if !inSplitter[s] {
+						inSplitter[s] = true
+						marked = append(marked, s)
+					}
+				}
+			}
+
+			touched := []int{}
+			for _, s := range marked {
+				if counts[blockOf[s]] == 0 {
+					touched = append(touched, blockOf[s])
+				}
+				counts[blockOf[s]]++
+			}
+
+			for _, block := range touched {
+				if counts[block] < len(blocks[block]) {
+					inside, outside := []int{}, []int{}
+					for _, s := range blocks[block] {
+						if inSplitter[s] {
+							inside = append(inside, s)
+						} else {
+							outside = append(outside, s)
+						}
+					}
+
+					newBlock := len(blocks)
+					blocks[block] = inside
+					blocks = append(blocks, outside)
+					isWaiting = append(isWaiting, false)
+					for _, s := range outside {
+						blockOf[s] = newBlock
+					}
+
+					switch {
+					case isWaiting[block]:
+						waiting = append(waiting, newBlock)
+						isWaiting[newBlock] = true
+					case len(inside) < len(outside):
+						waiting = append(waiting, block)
+						isWaiting[block] = true
+					default:
+						waiting = append(waiting, newBlock)
+						isWaiting[newBlock] = true
+					}
+				}
+				counts[block] = 0
+			}
+
+			for _, s := range marked {
+				inSplitter[s] = false
+			}
+		}
+	}
+
+	// number the blocks by their lowest state, so the dead state stays 0
+	order := make([]int, len(blocks))
+	for block := range blocks {
+		order[block] = block
+	}
+	slices.SortFunc(order, func(x, y int) int {
+		return slices.Min(blocks[x]) - slices.Min(blocks[y])
+	})
+	number := make([]uint32, len(blocks))
+	for i, block := range order {
+		number[block] = uint32(i)
+	}
+
+	minimized := &automaton{
+		classes:   a.classes,
+		stride:    a.stride,
+		next:      make([]uint32, a.stride*len(blocks)),
+		accept:    make([]bool, len(blocks)),
+		acceptEnd: make([]bool, len(blocks)),
+		start:     number[blockOf[a.start]],
+		startMid:  number[blockOf[a.startMid]],
+	}
+	for block, members := range blocks {
+		s, id := members[0], int(number[block])
+		minimized.accept[id] = a.accept[s]
+		minimized.acceptEnd[id] = a.acceptEnd[s]
+		for c := 0; c < a.stride; c++ {
+			minimized.next[id*a.stride+c] = number[blockOf[a.next[s*a.stride+c]]]
+		}
+	}
+	return minimized
+}
+
+/*
+equivalent reports whether two minimized automata are the same up to the
+numbering of their states, walking both from their start states at once,
+byte by byte as their byte classes may differ.
+*/
+func equivalent(a *automaton, b *automaton) bool {
+	pairs := map[uint32]uint32{a.start: b.start}
+	paired := map[uint32]bool{b.start: true}
+	stack := []uint32{a.start}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		t := pairs[s]
+		if a.accept[s] != b.accept[t] || a.acceptEnd[s] != b.acceptEnd[t] {
+			return false
+		}
+
+		// every byte, then the invalid bytes
+		for ch := 0; ch <= 256; ch++ {
+			classA, classB := a.classes.Invalid(), b.classes.Invalid()
+			if ch < 256 {
+				classA, classB = a.classes.Class(byte(ch)), b.classes.Class(byte(ch))
+			}
+			nextA := a.next[int(s)*a.stride+int(classA)]
+			nextB := b.next[int(t)*b.stride+int(classB)]
+			if other, ok := pairs[nextA]; ok {
+				if other != nextB {
+					return false
+				}
+				continue
+			}
+			if paired[nextB] {
+				return false
+			}
+			pairs[nextA] = nextB
+			paired[nextB] = true
+			stack = append(stack, nextA)
+		}
+	}
+	return true
+}
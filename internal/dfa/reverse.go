@@ -0,0 +1,79 @@
+package dfa
+
+import "github.com/rubuy-74/pstr/internal/models/state"
+
+/*
+reverse returns the initial state of an NFA matching the reverse of
+the inputs the NFA starting at nfa matches, read from their end to
+their start, so a search can find where a match starts from where it ends.
+- every transition leads back from its target to its source, on the
+same byte class
+- the initial state has epsilon transitions to the final states, and
+the initial state of nfa is the final one
+- an epsilon transition out of a state with an assertion leads back
+through a guard state with the same assertion, "^" and "$" being
+swapped as the input is read backwards
+*/
+func reverse(nfa *state.State) *state.State {
+	initial := &state.State{Initial: true, Classes: nfa.Classes}
+	reversed := map[*state.State]*state.State{}
+	guards := map[*state.State]*state.State{}
+
+	reversedOf := func(s *state.State) *state.State {
+		if r, ok := reversed[s]; ok {
+			return r
+		}
+		r := &state.State{Final: s == nfa, Transitions: map[uint8][]*state.State{}}
+		reversed[s] = r
+		return r
+	}
+	guardOf := func(s *state.State) *state.State {
+		if s.Assertion == state.NoAssertion {
+			return reversedOf(s)
+		}
+		if g, ok := guards[s]; ok {
+			return g
+		}
+		assertion := s.Assertion
+		switch assertion {
+		case state.TextStart:
+			assertion = state.TextEnd
+		case state.TextEnd:
+			assertion = state.TextStart
+		}
+		g := &state.State{Assertion: assertion, Epsilon: []*state.State{reversedOf(s)}}
+		guards[s] = g
+		return g
+	}
+
+	visited := map[*state.State]bool{nfa: true}
+	stack := []*state.State{nfa}
+	for len(stack) > 0 {
+		s := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if s.Final {
+			initial.Epsilon = append(initial.Epsilon, reversedOf(s))
+		}
+
+		next := []*state.State{}
+		for _, t := range s.Epsilon {
+			target := reversedOf(t)
+			target.Epsilon = append(target.Epsilon, guardOf(s))
+			next = append(next, t)
+		}
+		for class, targets := range s.Transitions {
+			for _, t := range targets {
+				target := reversedOf(t)
+				target.Transitions[class] = append(target.Transitions[class], reversedOf(s))
+				next = append(next, t)
+			}
+		}
+		for _, t := range next {
+			if !visited[t] {
+				visited[t] = true
+				stack = append(stack, t)
+			}
+		}
+	}
+	return initial
+}
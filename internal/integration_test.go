@@ -2,9 +2,13 @@
 package internal
 
 import (
+	"math/rand"
+	"slices"
+	"strings"
 	"testing"
 
 	"github.com/rubuy-74/pstr/internal/parser"
+	"github.com/rubuy-74/pstr/internal/regex"
 	"github.com/rubuy-74/pstr/internal/state_machine"
 )
 
@@ -77,7 +81,7 @@ func TestCompletePipelineReliability(t *testing.T) {
 			}
 
 			// Step 3: Test matching (should not crash)
-			valid := nfa.Check(tt.testString, -1)
+			valid := nfa.Check(tt.testString)
 
 			// For valid regexes, we expect the test to complete without crashing
 			// The actual matching result depends on the regex logic, not reliability
@@ -169,8 +173,1015 @@ func TestMemorySafety(t *testing.T) {
 			testStrings := []string{"", "a", "ab", "abc", "123", "a1b2c3"}
 			for _, testStr := range testStrings {
 				// This should not cause any memory access violations
-				_ = nfa.Check(testStr, -1)
+				_ = nfa.Check(testStr)
 			}
 		})
 	}
 }
+
+// matchTest is a regex, a string to check against it and the expected result
+type matchTest struct {
+	regex      string
+	testString string
+	expected   bool
+}
+
+// indexTest is a regex, an input to search and the expected offsets, nil for no match
+type indexTest struct {
+	regex    string
+	input    string
+	expected []int
+}
+
+// the engines tests run on: every engine for a behaviour they all share,
+// only the NFA one, or the one Compile picks
+var (
+	allEngines = []regex.Engine{regex.EngineNFA, regex.EngineDFA, regex.EngineLazyDFA, regex.EngineBacktrack}
+	nfaEngine  = []regex.Engine{regex.EngineNFA}
+	autoEngine = []regex.Engine{regex.EngineAuto}
+)
+
+/*
+runOnEngines compiles the regex with each of the engines, EngineAuto
+being the one Compile picks, and runs test on it in a subtest named
+after the regex and the input, and the engine when there are several.
+*/
+func runOnEngines(t *testing.T, engines []regex.Engine, regexString string, input string, test func(t *testing.T, re *regex.Regex)) {
+	t.Helper()
+	for _, engine := range engines {
+		name := regexString + "_" + input
+		if len(engines) > 1 {
+			name += "_" + engine.String()
+		}
+		t.Run(name, func(t *testing.T) {
+			re, err := regex.CompileWithEngine(regexString, engine)
+			if err != nil {
+				t.Fatalf("CompileWithEngine failed for %q: %v", regexString, err)
+			}
+			test(t, re)
+		})
+	}
+}
+
+// runMatchTests checks the string of every test with each of the engines
+func runMatchTests(t *testing.T, engines []regex.Engine, tests []matchTest) {
+	t.Helper()
+	for _, tt := range tests {
+		runOnEngines(t, engines, tt.regex, tt.testString, func(t *testing.T, re *regex.Regex) {
+			if valid, err := re.Check(tt.testString); err != nil || valid != tt.expected {
+				t.Errorf("Check(%q) on %q = %v, %v, expected %v", tt.testString, tt.regex, valid, err, tt.expected)
+			}
+		})
+	}
+}
+
+// runFindIndexTests searches the input of every test with each of the engines
+func runFindIndexTests(t *testing.T, engines []regex.Engine, tests []indexTest) {
+	t.Helper()
+	for _, tt := range tests {
+		runOnEngines(t, engines, tt.regex, tt.input, func(t *testing.T, re *regex.Regex) {
+			if index, err := re.FindIndex(tt.input); err != nil || !slices.Equal(index, tt.expected) {
+				t.Errorf("FindIndex(%q) on %q = %v, %v, expected %v", tt.input, tt.regex, index, err, tt.expected)
+			}
+		})
+	}
+}
+
+// runFindSubmatchIndexTests searches the input of every test for the match and its groups with each of the engines
+func runFindSubmatchIndexTests(t *testing.T, engines []regex.Engine, tests []indexTest) {
+	t.Helper()
+	for _, tt := range tests {
+		runOnEngines(t, engines, tt.regex, tt.input, func(t *testing.T, re *regex.Regex) {
+			if index, err := re.FindSubmatchIndex(tt.input); err != nil || !slices.Equal(index, tt.expected) {
+				t.Errorf("FindSubmatchIndex(%q) on %q = %v, %v, expected %v", tt.input, tt.regex, index, err, tt.expected)
+			}
+		})
+	}
+}
+
+// randomAtoms are the atoms of random regexes, the DFA engines supporting the ones before \w
+var randomAtoms = []string{"a", "b", "[ab]", ".", "^", "$", `\w`, `\B`, `\b`}
+
+// randomQuantifiers are the quantifiers of random regexes, empty ones repeating the atom once
+var randomQuantifiers = []string{"", "", "*", "+", "?", "*?", "+?", "??", "{0,2}", "{1,2}?"}
+
+// randomRegex returns a random regex over the given atoms, groups and alternations, nested up to depth
+func randomRegex(r *rand.Rand, depth int, atoms []string) string {
+	var sb strings.Builder
+	for i := r.Intn(3); i >= 0; i-- {
+		switch n := r.Intn(10); {
+		case depth > 0 && n < 2:
+			sb.WriteString("(" + randomRegex(r, depth-1, atoms) + ")")
+		case depth > 0 && n < 3:
+			sb.WriteString("(?:" + randomRegex(r, depth-1, atoms) + "|" + randomRegex(r, depth-1, atoms) + ")")
+		default:
+			sb.WriteString(atoms[r.Intn(len(atoms))])
+		}
+		sb.WriteString(randomQuantifiers[r.Intn(len(randomQuantifiers))])
+	}
+	return sb.String()
+}
+
+// randomInput returns a random input of fewer than limit characters among a, b and a space
+func randomInput(r *rand.Rand, limit int) string {
+	input := []byte{}
+	for j := r.Intn(limit); j > 0; j-- {
+		input = append(input, "ab "[r.Intn(3)])
+	}
+	return string(input)
+}
+
+// TestNestedMatching tests matching of patterns with nested groups and alternations
+func TestNestedMatching(t *testing.T) {
+	tests := []matchTest{
+		{"((a|b)c)", "ac", true},
+		{"((a|b)c)", "bc", true},
+		{"((a|b)c)", "cc", false},
+		{"(a(b|c)d)e", "acde", true},
+		{"(a(b|c)d)e", "abe", false},
+		{"x(a|bc|d)y", "xbcy", true},
+		{"x(a|bc|d)y", "xdy", true},
+		{"x(a|bc|d)y", "xby", false},
+		{"((a|b)|(c|d))", "d", true},
+		{"a*|b", "aaa", true},
+	}
+
+	runMatchTests(t, nfaEngine, tests)
+}
+
+// TestQuantifiedSubexpressionMatching tests that quantifiers repeat whole groups and brackets
+func TestQuantifiedSubexpressionMatching(t *testing.T) {
+	tests := []matchTest{
+		{"(ab)*", "", true},
+		{"(ab)*", "abab", true},
+		{"(ab)*", "aba", false},
+		{"(a|b){2,4}", "a", false},
+		{"(a|b){2,4}", "ab", true},
+		{"(a|b){2,4}", "abba", true},
+		{"(a|b){2,4}", "ababa", false},
+		{"[0-9]+", "", false},
+		{"[0-9]+", "123", true},
+		{"([a-z]){2}", "ab", true},
+		{"([a-z]){2}", "abc", false},
+		{"((ab)+c)*", "ababcabc", true},
+		{"((ab)+c)*", "abcab", false},
+		{"(a{2}){2}", "aaaa", true},
+		{"(a{2}){2}", "aaa", false},
+		{"a{,2}", "", true},
+		{"a{,2}", "aaa", false},
+		{"a{0}", "a", false},
+	}
+
+	runMatchTests(t, nfaEngine, tests)
+}
+
+// TestNullableRepetitionMatching tests that repeating a token that can match nothing terminates
+func TestNullableRepetitionMatching(t *testing.T) {
+	tests := []matchTest{
+		{"(a*)*", "", true},
+		{"(a*)*", "aaa", true},
+		{"(a*)*", "aab", false},
+		{"(a?)*", "aa", true},
+		{"(a?)*b", "aab", true},
+		{"(a*)+", "", true},
+		{"(a|b?)+", "aba", true},
+		{"(a*|b)*", "abba", true},
+		{"(a*)*c", "aaab", false},
+		{"^*a", "a", true},
+		{`()*a`, "a", true},
+		{"(?:a*b*)*c", "abbac", true},
+	}
+
+	runMatchTests(t, nfaEngine, tests)
+}
+
+// TestCheckLongInput tests that the NFA checks long inputs without deep recursion
+func TestCheckLongInput(t *testing.T) {
+	input := strings.Repeat("ab", 50000) + "c"
+	ctx, err := parser.Parse("(a|b)*c")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	nfa, err := state_machine.ToNFA(ctx)
+	if err != nil {
+		t.Fatalf("ToNFA failed: %v", err)
+	}
+	if !nfa.Check(input) {
+		t.Errorf("expected a match on a long input")
+	}
+	if nfa.Check(input[:len(input)-1]) {
+		t.Errorf("expected no match without the final c")
+	}
+}
+
+// TestFindLongEpsilonChain tests that the NFA follows long chains of epsilon transitions when searching
+func TestFindLongEpsilonChain(t *testing.T) {
+	ctx, err := parser.Parse(strings.Repeat("a?", 5000) + "b")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	nfa, err := state_machine.ToNFA(ctx)
+	if err != nil {
+		t.Fatalf("ToNFA failed: %v", err)
+	}
+	if index := nfa.FindIndex("cab"); !slices.Equal(index, []int{1, 3}) {
+		t.Errorf("FindIndex = %v, expected [1 3]", index)
+	}
+}
+
+// TestEscapeMatching tests matching of escaped metacharacters and character codes
+func TestEscapeMatching(t *testing.T) {
+	tests := []matchTest{
+		{`a\*`, "a*", true},
+		{`a\*`, "aa", false},
+		{`\(a\|b\)`, "(a|b)", true},
+		{`\(a\|b\)`, "a", false},
+		{`a\x00b`, "a\x00b", true},
+		{`a\x00b`, "ab", false},
+		{`\x41+`, "AAA", true},
+		{`\Q(a|b)*\E+`, "(a|b)**", true},
+		{`\Q(a|b)*\E+`, "(a|b)", false},
+		{`line\nbreak`, "line\nbreak", true},
+	}
+
+	runMatchTests(t, nfaEngine, tests)
+}
+
+// TestQuoteMetaMatching tests that a quoted string matches itself literally
+func TestQuoteMetaMatching(t *testing.T) {
+	inputs := []string{"1.5*2", "(a|b)", "[x]{2}", `back\slash`, "a+b?"}
+
+	for _, input := range inputs {
+		ctx, err := parser.Parse(parser.QuoteMeta(input))
+		if err != nil {
+			t.Fatalf("Parse failed for quoted %q: %v", input, err)
+		}
+
+		nfa, err := state_machine.ToNFA(ctx)
+		if err != nil {
+			t.Fatalf("ToNFA failed for quoted %q: %v", input, err)
+		}
+
+		if !nfa.Check(input) {
+			t.Errorf("expected quoted %q to match itself", input)
+		}
+	}
+}
+
+// TestClassMatching tests matching of shorthand and POSIX classes
+func TestClassMatching(t *testing.T) {
+	tests := []matchTest{
+		{`\d+`, "2024", true},
+		{`\d+`, "20a4", false},
+		{`\D+`, "abc", true},
+		{`\D+`, "ab1", false},
+		{`\w+`, "snake_case9", true},
+		{`\w+`, "kebab-case", false},
+		{`\W`, "-", true},
+		{`\W`, "\xff", true},
+		{`\W`, "é", true},
+		{`a\sb`, "a\tb", true},
+		{`\S+`, "a b", false},
+		{`[\d_]+`, "1_000", true},
+		{`[[:alpha:]]+`, "Hello", true},
+		{`[[:alpha:]]+`, "Hello1", false},
+		{`[[:xdigit:]]{2}`, "fF", true},
+		{`[[:^space:]]+`, "no-spaces", true},
+		{`[[:^space:]]+`, "a space", false},
+	}
+
+	runMatchTests(t, nfaEngine, tests)
+}
+
+// TestBracketMatching tests matching of negated and mixed character classes
+func TestBracketMatching(t *testing.T) {
+	tests := []matchTest{
+		{"[abc]+", "cab", true},
+		{"[abc]+", "cad", false},
+		{"[a-zA-Z_]+", "Snake_Case", true},
+		{"[a-zA-Z_]+", "Snake-Case", false},
+		{"[^0-9]+", "abc", true},
+		{"[^0-9]+", "a1c", false},
+		{"[^a]", "\xff", true},
+		{"[^a]", "é", true},
+		{"[-a]+", "-a-", true},
+		{"[a-]+", "a-b", false},
+		{"[]a]+", "]a]", true},
+		{"[^]a]", "b", true},
+		{"[^]a]", "]", false},
+	}
+
+	runMatchTests(t, nfaEngine, tests)
+}
+
+// TestDotAndAnchorMatching tests matching of the dot wildcard and the ^ $ anchors
+func TestDotAndAnchorMatching(t *testing.T) {
+	tests := []matchTest{
+		{"a.c", "abc", true},
+		{"a.c", "a.c", true},
+		{"a.c", "a\nc", false},
+		{"a.c", "ac", false},
+		{".*", "any thing", true},
+		{"^abc$", "abc", true},
+		{"^abc$", "abcd", false},
+		{"a^b", "ab", false},
+		{"a$b", "ab", false},
+		{"^$", "", true},
+		{"(^a|b)c", "ac", true},
+		{"x(^a|b)c", "xac", false},
+		{"x(^a|b)c", "xbc", true},
+		{"(a$|b)", "a", true},
+	}
+
+	runMatchTests(t, nfaEngine, tests)
+}
+
+// TestDotNLMatching tests that the DotNL flag lets the dot match newlines
+func TestDotNLMatching(t *testing.T) {
+	ctx, err := parser.ParseWithFlags("a.c", parser.DotNL)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	nfa, err := state_machine.ToNFA(ctx)
+	if err != nil {
+		t.Fatalf("ToNFA failed: %v", err)
+	}
+
+	if !nfa.Check("a\nc") {
+		t.Errorf("expected a.c to match a newline with DotNL")
+	}
+}
+
+// TestFindIndex tests the unanchored search of the leftmost match
+func TestFindIndex(t *testing.T) {
+	tests := []indexTest{
+		{"abc", "xxabcxx", []int{2, 5}},
+		{"abc", "ababab", nil},
+		{"a+", "baaab", []int{1, 4}},
+		{"a*", "baaa", []int{0, 0}},
+		{"[0-9]+", "error 404 at line 12", []int{6, 9}},
+		{"a|ab", "xab", []int{1, 2}},
+		{"ab|a", "xab", []int{1, 3}},
+		{"abc|b", "abc", []int{0, 3}},
+		{"abd|b", "abc", []int{1, 2}},
+		{"^a", "ba", nil},
+		{"^b", "ba", []int{0, 1}},
+		{"a$", "aab", nil},
+		{"b$", "aab", []int{2, 3}},
+		{"x*", "", []int{0, 0}},
+		{"(a|b)*c", "zzababcab", []int{2, 7}},
+	}
+
+	runFindIndexTests(t, nfaEngine, tests)
+}
+
+// TestFind tests that Find returns the matched text
+func TestFind(t *testing.T) {
+	ctx, err := parser.Parse(`\d+`)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	nfa, err := state_machine.ToNFA(ctx)
+	if err != nil {
+		t.Fatalf("ToNFA failed: %v", err)
+	}
+
+	if match, found := nfa.Find("GET /items/42 HTTP/1.1"); !found || match != "42" {
+		t.Errorf("expected to find \"42\", got %q (found: %v)", match, found)
+	}
+	if match, found := nfa.Find("no digits"); found {
+		t.Errorf("expected no match, got %q", match)
+	}
+}
+
+// TestFindSubmatchIndex tests the offsets of the capturing groups of a match
+func TestFindSubmatchIndex(t *testing.T) {
+	tests := []indexTest{
+		{"(a)(b)", "xab", []int{1, 3, 1, 2, 2, 3}},
+		{"(a)(b)?", "ac", []int{0, 1, 0, 1, -1, -1}},
+		{"(a|b)*", "abba", []int{0, 4, 3, 4}},
+		{"((a)b)c", "abc", []int{0, 3, 0, 2, 0, 1}},
+		{"(a*)b", "b", []int{0, 1, 0, 0}},
+		{`(\d+)-(\d+)`, "call 555-1234 now", []int{5, 13, 5, 8, 9, 13}},
+		{"(x)", "abc", nil},
+	}
+
+	runFindSubmatchIndexTests(t, autoEngine, tests)
+}
+
+// TestEmptyIterations tests that repetitions of a token matching nothing find the same match and groups as Go
+func TestEmptyIterations(t *testing.T) {
+	tests := []indexTest{
+		{"(?:a*|b)+", "b", []int{0, 0}},
+		{"(?:a*|b)*", "b", []int{0, 0}},
+		{"(a*)*b", "b", []int{0, 1, 0, 0}},
+		{"(a?)*b", "b", []int{0, 1, 0, 0}},
+		{"(a?)*b", "aab", []int{0, 3, 1, 2}},
+		{"(a*)+", "aa", []int{0, 2, 0, 2}},
+		{"(a*){2,}b", "aab", []int{0, 3, 2, 2}},
+		{"(.*?){1,}?$", "a  a", []int{0, 4, 0, 4}},
+		{"((.*?)*?[ab])", "  ab", []int{0, 3, 0, 3, 0, 2}},
+	}
+
+	runFindSubmatchIndexTests(t, allEngines, tests)
+}
+
+// TestFindSubmatch tests that FindSubmatch returns the text of each group
+func TestFindSubmatch(t *testing.T) {
+	re, err := regex.Compile(`(\w+)@(\w+)\.com`)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	expected := []string{"jane@example.com", "jane", "example"}
+	if submatches, err := re.FindSubmatch("mail jane@example.com today"); err != nil || !slices.Equal(submatches, expected) {
+		t.Errorf("expected %q, got %q, %v", expected, submatches, err)
+	}
+	if submatches, err := re.FindSubmatch("no address"); err != nil || submatches != nil {
+		t.Errorf("expected no match, got %q, %v", submatches, err)
+	}
+}
+
+// TestNamedGroups tests the lookup of capturing groups by name
+func TestNamedGroups(t *testing.T) {
+	re, err := regex.Compile(`(?P<user>\w+)@(\w+)\.(?<tld>com|org)?`)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	expectedNames := []string{"", "user", "", "tld"}
+	if names := re.SubexpNames(); !slices.Equal(names, expectedNames) {
+		t.Errorf("expected names %q, got %q", expectedNames, names)
+	}
+	if index := re.SubexpIndex("tld"); index != 3 {
+		t.Errorf("expected tld at index 3, got %d", index)
+	}
+	if index := re.SubexpIndex("missing"); index != -1 {
+		t.Errorf("expected -1 for an unknown name, got %d", index)
+	}
+	if index := re.SubexpIndex(""); index != -1 {
+		t.Errorf("expected -1 for the empty name, got %d", index)
+	}
+
+	submatches, err := re.FindSubmatchMap("jane@example.org")
+	if err != nil || len(submatches) != 2 || submatches["user"] != "jane" || submatches["tld"] != "org" {
+		t.Errorf("unexpected named submatches %q, %v", submatches, err)
+	}
+
+	submatches, err = re.FindSubmatchMap("jane@example.")
+	if _, ok := submatches["tld"]; err != nil || ok || submatches["user"] != "jane" {
+		t.Errorf("expected only user in the named submatches, got %q, %v", submatches, err)
+	}
+
+	if submatches, err := re.FindSubmatchMap("nobody"); err != nil || submatches != nil {
+		t.Errorf("expected no match, got %q, %v", submatches, err)
+	}
+}
+
+// TestNonCapturingGroups tests that (?:...) groups match without capturing
+func TestNonCapturingGroups(t *testing.T) {
+	tests := []indexTest{
+		{"(?:ab)+", "xababx", []int{1, 5}},
+		{"(?:a|b)(c)", "bc", []int{0, 2, 1, 2}},
+		{"(?:(a)|b)+", "ab", []int{0, 2, 0, 1}},
+		{"(?:x(?:y|z))*w", "xyxzw", []int{0, 5}},
+	}
+
+	runFindSubmatchIndexTests(t, autoEngine, tests)
+}
+
+// TestLazyQuantifiers tests that lazy quantifiers prefer the shortest match
+func TestLazyQuantifiers(t *testing.T) {
+	tests := []indexTest{
+		{"<.+?>", "<a><b>", []int{0, 3}},
+		{"<.+>", "<a><b>", []int{0, 6}},
+		{"a*?", "aaa", []int{0, 0}},
+		{"a+?", "aaa", []int{0, 1}},
+		{"a??b", "ab", []int{0, 2}},
+		{"a{2,4}?", "aaaa", []int{0, 2}},
+		{"(a+?)(a*)", "aaa", []int{0, 3, 0, 1, 1, 3}},
+		{"(.*?)-(.*)", "x-y-z", []int{0, 5, 0, 1, 2, 5}},
+		{"a.*?b", "axxbyyb", []int{0, 4}},
+	}
+
+	runFindSubmatchIndexTests(t, autoEngine, tests)
+}
+
+// TestLazyQuantifierMatching tests that laziness does not change the strings matched
+func TestLazyQuantifierMatching(t *testing.T) {
+	tests := []matchTest{
+		{"a*?", "aaa", true},
+		{"a+?b", "aab", true},
+		{"a+?b", "b", false},
+		{"<.+?>", "<a><b>", true},
+		{"a{2,3}?", "aaaa", false},
+	}
+	runMatchTests(t, nfaEngine, tests)
+}
+
+// TestEngines tests that the engine is chosen at compile time and checked against the regex
+func TestEngines(t *testing.T) {
+	if _, err := regex.CompileWithEngine("a*+b", regex.EngineNFA); err == nil {
+		t.Errorf("expected the NFA engine to reject a possessive quantifier")
+	}
+	if _, err := regex.CompileWithEngine("x(?>a|ab)", regex.EngineNFA); err == nil {
+		t.Errorf("expected the NFA engine to reject an atomic group")
+	}
+
+	re, err := regex.CompileWithEngine(`(\w++)=(\d+)`, regex.EngineBacktrack)
+	if err != nil {
+		t.Fatalf("CompileWithEngine failed: %v", err)
+	}
+	for input, expected := range map[string]bool{"count=42": true, "count=": false} {
+		if match, err := re.Check(input); err != nil || match != expected {
+			t.Errorf("Check(%q) with the backtracking engine = %v, %v, expected %v", input, match, err, expected)
+		}
+	}
+	expected := []string{"count=42", "count", "42"}
+	if submatches, err := re.FindSubmatch("set count=42;"); err != nil || !slices.Equal(submatches, expected) {
+		t.Errorf("expected %q, got %q, %v", expected, submatches, err)
+	}
+	if match, found, err := re.Find("a=b"); err != nil || found {
+		t.Errorf("expected no match, got %q, %v", match, err)
+	}
+
+	if engine, err := regex.ParseEngine("backtrack"); err != nil || engine != regex.EngineBacktrack {
+		t.Errorf("expected the backtracking engine, got %v (%v)", engine, err)
+	}
+	if _, err := regex.ParseEngine("jit"); err == nil {
+		t.Errorf("expected error for an unknown engine")
+	}
+}
+
+// TestEngineSelection tests that Compile only picks the backtracking engine when the regex needs it
+func TestEngineSelection(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected regex.Engine
+	}{
+		{"(a|b)*c", regex.EngineNFA},
+		{`(?<word>\w+) \k<word>`, regex.EngineBacktrack},
+		{`(a)\1`, regex.EngineBacktrack},
+		{"(?:x(?>y))*", regex.EngineBacktrack},
+		{`(a)\101`, regex.EngineNFA},
+	}
+
+	for _, tt := range tests {
+		re, err := regex.Compile(tt.regex)
+		if err != nil {
+			t.Fatalf("Compile failed for %q: %v", tt.regex, err)
+		}
+		if re.Engine != tt.expected {
+			t.Errorf("expected engine %v for %q, got %v", tt.expected, tt.regex, re.Engine)
+		}
+	}
+
+	if _, err := regex.CompileWithEngine(`(a)\1`, regex.EngineNFA); err == nil {
+		t.Errorf("expected the NFA engine to reject a backreference")
+	}
+}
+
+// TestDFAEngine tests that the DFA engine matches like the NFA engine, and falls back to it when it has to
+func TestDFAEngine(t *testing.T) {
+	re, err := regex.CompileWithEngine(`(\w+)@(\w+)\.com`, regex.EngineDFA)
+	if err != nil {
+		t.Fatalf("CompileWithEngine failed: %v", err)
+	}
+	if re.Engine != regex.EngineDFA || re.DFA == nil {
+		t.Fatalf("expected the DFA engine, got %v", re.Engine)
+	}
+	for input, expected := range map[string]bool{"user@example.com": true, "user@example.org": false} {
+		if match, err := re.Check(input); err != nil || match != expected {
+			t.Errorf("Check(%q) with the DFA engine = %v, %v, expected %v", input, match, err, expected)
+		}
+	}
+	if index, err := re.FindIndex("mail user@example.com now"); err != nil || !slices.Equal(index, []int{5, 21}) {
+		t.Errorf("expected [5 21], got %v, %v", index, err)
+	}
+	expected := []string{"user@example.com", "user", "example"}
+	if submatches, err := re.FindSubmatch("mail user@example.com now"); err != nil || !slices.Equal(submatches, expected) {
+		t.Errorf("expected %q, got %q, %v", expected, submatches, err)
+	}
+	if submatches, err := re.FindSubmatch("no mail"); err != nil || submatches != nil {
+		t.Errorf("expected no match, got %q, %v", submatches, err)
+	}
+
+	// the NFA finds the groups over the match of the DFA, its assertions looking at the whole input
+	submatchTests := []struct {
+		regex string
+		input string
+	}{
+		{"(a+)(b*)$", "aab aab"},
+		{`(?:^|,)(\w*)`, "x,yz"},
+		{"(a|ab)(c|bcd)", "xabcd"},
+		{"(a*)+", "b"},
+		{"(a)|b", "cb"},
+	}
+	for _, tt := range submatchTests {
+		nfa, err := regex.CompileWithEngine(tt.regex, regex.EngineNFA)
+		if err != nil {
+			t.Fatalf("CompileWithEngine failed for %q: %v", tt.regex, err)
+		}
+		expected, err := nfa.FindSubmatchIndex(tt.input)
+		if err != nil {
+			t.Fatalf("FindSubmatchIndex failed for %q: %v", tt.regex, err)
+		}
+		for _, engine := range []regex.Engine{regex.EngineDFA, regex.EngineLazyDFA} {
+			re, err := regex.CompileWithEngine(tt.regex, engine)
+			if err != nil {
+				t.Fatalf("CompileWithEngine failed for %q: %v", tt.regex, err)
+			}
+			if index, err := re.FindSubmatchIndex(tt.input); err != nil || !slices.Equal(index, expected) {
+				t.Errorf("FindSubmatchIndex(%q) on %q with %v = %v, %v, expected %v", tt.input, tt.regex, engine, index, err, expected)
+			}
+		}
+	}
+
+	tests := []struct {
+		regex    string
+		options  regex.Options
+		expected regex.Engine
+	}{
+		{`\bword\b`, regex.Options{Engine: regex.EngineDFA}, regex.EngineNFA},
+		{"(?m)^a$", regex.Options{Engine: regex.EngineDFA}, regex.EngineNFA},
+		{`\bword\b`, regex.Options{Engine: regex.EngineLazyDFA}, regex.EngineNFA},
+		{"(a|b)*a(a|b){10}", regex.Options{Engine: regex.EngineDFA, MaxDFAStates: 64}, regex.EngineLazyDFA},
+		{"(a|b)*a(a|b){10}", regex.Options{Engine: regex.EngineLazyDFA, MaxDFAStates: 64}, regex.EngineLazyDFA},
+	}
+	for _, tt := range tests {
+		re, err := regex.CompileWithOptions(tt.regex, tt.options)
+		if err != nil {
+			t.Fatalf("CompileWithOptions failed for %q: %v", tt.regex, err)
+		}
+		if re.Engine != tt.expected {
+			t.Errorf("expected %q to fall back to engine %v, got %v", tt.regex, tt.expected, re.Engine)
+		}
+	}
+
+	re, err = regex.CompileWithOptions("(a|b)*a(a|b){20}", regex.Options{Engine: regex.EngineDFA, MaxDFAStates: 32})
+	if err != nil {
+		t.Fatalf("CompileWithOptions failed: %v", err)
+	}
+	input := strings.Repeat("ab", 50) + strings.Repeat("b", 19)
+	if re.Engine != regex.EngineLazyDFA {
+		t.Fatalf("expected the lazy DFA engine, got %v", re.Engine)
+	}
+	for input, expected := range map[string]bool{input: true, input + "b": false} {
+		if match, err := re.Check(input); err != nil || match != expected {
+			t.Errorf("Check(%q) with the lazy DFA engine = %v, %v, expected %v", input, match, err, expected)
+		}
+	}
+	if index, err := re.FindIndex("cbb" + input + "b"); err != nil || !slices.Equal(index, []int{1, 122}) {
+		t.Errorf("expected [1 122], got %v, %v", index, err)
+	}
+	if stats := re.Lazy.Stats(); stats.Misses == 0 || stats.States > 3*32 {
+		t.Errorf("unexpected lazy DFA cache statistics %+v", stats)
+	}
+
+	if _, err := regex.CompileWithEngine(`(a)\1`, regex.EngineDFA); err == nil {
+		t.Errorf("expected the DFA engine to reject a backreference")
+	}
+	if engine, err := regex.ParseEngine("dfa"); err != nil || engine != regex.EngineDFA {
+		t.Errorf("expected the DFA engine, got %v (%v)", engine, err)
+	}
+}
+
+// variant is a regex and the options to compile it with
+type variant struct {
+	regex   string
+	options regex.Options
+}
+
+/*
+checkSameAsNFA checks that the variants find the same matches as the NFA
+of the regex on random inputs of fewer than limit characters. Returns false,
+checking nothing, when the regex does not compile.
+*/
+func checkSameAsNFA(t *testing.T, r *rand.Rand, regexString string, limit int, variants ...variant) bool {
+	t.Helper()
+	nfa, err := regex.CompileWithEngine(regexString, regex.EngineNFA)
+	if err != nil {
+		return false
+	}
+	compiled := make([]*regex.Regex, len(variants))
+	for i, v := range variants {
+		if compiled[i], err = regex.CompileWithOptions(v.regex, v.options); err != nil {
+			t.Fatalf("CompileWithOptions failed for %q with %v: %v", v.regex, v.options.Engine, err)
+		}
+	}
+
+	for i := 0; i < 5; i++ {
+		input := randomInput(r, limit)
+		expected, _ := nfa.FindSubmatchIndex(input)
+		expectedMatch, _ := nfa.Check(input)
+		for j, re := range compiled {
+			if index, err := re.FindSubmatchIndex(input); err != nil || !slices.Equal(index, expected) {
+				t.Errorf("FindSubmatchIndex(%q) on %q with %v = %v, %v, expected %v", input, variants[j].regex, re.Engine, index, err, expected)
+			}
+			if match, err := re.Check(input); err != nil || match != expectedMatch {
+				t.Errorf("Check(%q) on %q with %v = %v, %v, expected %v", input, variants[j].regex, re.Engine, match, err, expectedMatch)
+			}
+		}
+	}
+	return true
+}
+
+/*
+TestRandomSameAsNFA tests that the other engines find the same matches as
+the NFA on random regexes: the DFA engines on the ones they support, the
+lazy one with a cache of 2 states, and the backtracker on all of them,
+also behind a lookahead that always holds, which makes the regex need it
+*/
+func TestRandomSameAsNFA(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for tested := 0; tested < 500; {
+		regexString := randomRegex(r, 3, randomAtoms[:6])
+		if checkSameAsNFA(t, r, regexString, 8,
+			variant{regexString, regex.Options{Engine: regex.EngineDFA}},
+			variant{regexString, regex.Options{Engine: regex.EngineLazyDFA, MaxDFAStates: 2}}) {
+			tested++
+		}
+	}
+	for tested := 0; tested < 2000; {
+		regexString := randomRegex(r, 3, randomAtoms)
+		if checkSameAsNFA(t, r, regexString, 6,
+			variant{regexString, regex.Options{Engine: regex.EngineBacktrack}},
+			variant{"(?=.?)" + regexString, regex.Options{Engine: regex.EngineBacktrack}}) {
+			tested++
+		}
+	}
+}
+
+// TestBackreferences tests that backreferences match the text captured by their group
+func TestBackreferences(t *testing.T) {
+	tests := []matchTest{
+		{`(\w+) \1`, "hello hello", true},
+		{`(\w+) \1`, "hello world", false},
+		{`(?P<quote>['"]).*\k<quote>`, `"it's"`, true},
+		{`(?P<quote>['"]).*\k<quote>`, `"it's'`, false},
+		{`(a|b)\1+`, "aaaa", true},
+		{`(a|b)\1+`, "abab", false},
+		{`(a)?b\1`, "b", false},
+		{`(a*)b\1`, "b", true},
+	}
+
+	runMatchTests(t, autoEngine, tests)
+
+	re, err := regex.Compile(`(\d+)-\1`)
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	expected := []int{4, 9, 4, 6}
+	if index, err := re.FindSubmatchIndex("see 12-12-13"); err != nil || !slices.Equal(index, expected) {
+		t.Errorf("expected %v, got %v, %v", expected, index, err)
+	}
+}
+
+// TestLookaroundMatching tests lookarounds through the automatically selected engine
+func TestLookaroundMatching(t *testing.T) {
+	// at least 8 characters, with a digit and an uppercase letter
+	password := `^(?=.*\d)(?=.*[A-Z]).{8,}$`
+	tests := []matchTest{
+		{password, "Secret123", true},
+		{password, "secret123", false},
+		{password, "SecretPass", false},
+		{password, "Sec123", false},
+		{`(?<!un)able`, "able", true},
+		{`\w*(?<!un)able`, "unable", false},
+		{`\w*(?<!un)able`, "capable", true},
+	}
+
+	for _, tt := range tests {
+		runOnEngines(t, autoEngine, tt.regex, tt.testString, func(t *testing.T, re *regex.Regex) {
+			if re.Engine != regex.EngineBacktrack {
+				t.Errorf("expected the backtracking engine for %q, got %v", tt.regex, re.Engine)
+			}
+		})
+	}
+	runMatchTests(t, autoEngine, tests)
+}
+
+// TestWordBoundaryAndTextEdges tests the \b \B \A \z \Z assertions with the NFA engine
+func TestWordBoundaryAndTextEdges(t *testing.T) {
+	tests := []indexTest{
+		{`\bfoo\b`, "foobar foo bar", []int{7, 10}},
+		{`\bfoo\b`, "foo", []int{0, 3}},
+		{`\bfoo\b`, "foobar", nil},
+		{`\Bbar`, "foobar bar", []int{3, 6}},
+		{`\b`, "  ", nil},
+		{`\B`, "ab", []int{1, 1}},
+		{`\w+\b`, "hi, there", []int{0, 2}},
+		{`\Aab`, "abab", []int{0, 2}},
+		{`ab\z`, "abab", []int{2, 4}},
+		{`ab\z`, "ab\n", nil},
+		{`ab\Z`, "ab\n", []int{0, 2}},
+		{`ab\Z`, "ab\n\n", nil},
+		{`\b\d+\b`, "abc123 456", []int{7, 10}},
+	}
+
+	for _, tt := range tests {
+		runOnEngines(t, autoEngine, tt.regex, tt.input, func(t *testing.T, re *regex.Regex) {
+			if re.Engine != regex.EngineNFA {
+				t.Errorf("expected the NFA engine for %q, got %v", tt.regex, re.Engine)
+			}
+		})
+	}
+	runFindIndexTests(t, []regex.Engine{regex.EngineNFA, regex.EngineBacktrack}, tests)
+
+	checks := []matchTest{
+		{`\bfoo\b`, "foo", true},
+		{`foo\b`, "foo", true},
+		{`\Bfoo`, "foo", false},
+		{`\Afoo\z`, "foo", true},
+		{`foo\Z`, "foo\n", false},
+	}
+	runMatchTests(t, nfaEngine, checks)
+}
+
+// TestInlineFlagMatching tests that inline flags change how the regex matches
+func TestInlineFlagMatching(t *testing.T) {
+	tests := []matchTest{
+		{"(?i)hello", "HeLLo", true},
+		{"(?i)[a-c]+", "AbC", true},
+		{"(?i)[^a-c]", "B", false},
+		{"(?i)[[:upper:]]", "q", true},
+		{"a(?i)b", "AB", false},
+		{"a(?i)b", "aB", true},
+		{"(?i:a)b", "AB", false},
+		{`(?i)\Qa.b\E`, "A.B", true},
+		{"(?s).", "\n", true},
+		{".", "\n", false},
+		{"(?x) a + b # trailing comment", "aab", true},
+	}
+	runMatchTests(t, nfaEngine, tests)
+}
+
+// TestMultiLine tests that the m flag makes ^ and $ match at line edges
+func TestMultiLine(t *testing.T) {
+	tests := []indexTest{
+		{"^b", "a\nb", nil},
+		{"(?m)^b", "a\nb", []int{2, 3}},
+		{"(?m)a$", "a\nb", []int{0, 1}},
+		{"a$", "a\nb", nil},
+		{`(?m)\Ab`, "a\nb", nil},
+		{"(?m)^$", "a\n\nb", []int{2, 2}},
+	}
+
+	runFindIndexTests(t, autoEngine, tests)
+}
+
+// TestCaseInsensitiveOption tests the case-insensitive compile option
+func TestCaseInsensitiveOption(t *testing.T) {
+	tests := []struct {
+		regex     string
+		input     string
+		expected  bool
+		sensitive bool
+	}{
+		{"content-type", "Content-Type", true, false},
+		{`select\s+\*\s+from`, "SELECT * FROM", true, false},
+		{"[a-f0-9]+", "DEADbeef", true, false},
+		{"[^a-z]", "Q", false, true},
+		{`\w+`, "MiXeD", true, true},
+		{"(?-i)abc", "ABC", false, false},
+		{"(?-i:a)bc", "aBC", true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.regex+"_"+tt.input, func(t *testing.T) {
+			re, err := regex.CompileWithFlags(tt.regex, parser.FoldCase)
+			if err != nil {
+				t.Fatalf("CompileWithFlags failed for %q: %v", tt.regex, err)
+			}
+			if result, err := re.Check(tt.input); err != nil || result != tt.expected {
+				t.Errorf("Check(%q) on %q = %v, %v, expected %v", tt.input, tt.regex, result, err, tt.expected)
+			}
+
+			sensitive, err := regex.Compile(tt.regex)
+			if err != nil {
+				t.Fatalf("Compile failed for %q: %v", tt.regex, err)
+			}
+			if result, err := sensitive.Check(tt.input); err != nil || result != tt.sensitive {
+				t.Errorf("Check(%q) on %q without the option = %v, %v, expected %v", tt.input, tt.regex, result, err, tt.sensitive)
+			}
+		})
+	}
+
+	re, err := regex.CompileWithOptions(`(\w+)=\1`, regex.Options{Flags: parser.FoldCase})
+	if err != nil {
+		t.Fatalf("CompileWithOptions failed: %v", err)
+	}
+	if match, err := re.Check("Key=KEY"); re.Engine != regex.EngineBacktrack || err != nil || !match {
+		t.Errorf("expected a case-insensitive backreference on the backtracking engine, got %v, %v", match, err)
+	}
+}
+
+// TestUnicodeMatching tests that patterns and inputs are matched rune by rune on both engines
+func TestUnicodeMatching(t *testing.T) {
+	tests := []indexTest{
+		{"é+", "caféé", []int{3, 7}},
+		{"[à-ü]+", "niño", []int{2, 4}},
+		{"[^a-z]", "a€b", []int{1, 4}},
+		{"a.c", "a😀c", []int{0, 6}},
+		{".", "\xffa", []int{0, 1}},
+		{`\W+`, "olá mundo", []int{2, 5}},
+		{"(?i)é", "CAFÉ", []int{3, 5}},
+		{"(?i)k", "K", []int{0, 3}},
+		{"(?i)straße", "STRASSE", nil},
+		{`\x{1f600}`, "hi 😀", []int{3, 7}},
+	}
+
+	runFindIndexTests(t, allEngines, tests)
+
+	re, err := regex.Compile("(?<=ü)ber")
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	if index, err := re.FindIndex("über"); err != nil || !slices.Equal(index, []int{2, 5}) {
+		t.Errorf("expected a lookbehind on a two-byte rune to match at [2 5], got %v, %v", index, err)
+	}
+}
+
+// TestInvalidUTF8 tests that an invalid byte of an input is matched as U+FFFD on all engines
+func TestInvalidUTF8(t *testing.T) {
+	tests := []indexTest{
+		{".*", "caf\xe9", []int{0, 4}},
+		{"(?s).", "\xff", []int{0, 1}},
+		{"[^a]", "\xff", []int{0, 1}},
+		{`\S`, "\xff", []int{0, 1}},
+		{`\PL`, "a\xff", []int{1, 2}},
+		{`\x{fffd}`, "a\x80", []int{1, 2}},
+		{"a", "\xff", nil},
+		{"é", "\xc3", nil},
+		// \xe2 and \x84 do not form a rune without a third byte
+		{"..x", "\xe2\x84x", []int{0, 3}},
+		{".x", "\xe2\x84x", []int{1, 3}},
+		// the bytes of a valid rune are not invalid on their own
+		{"..", "é", nil},
+		{"[^é]", "é", nil},
+		{".", "\xe2\x84\xaa", []int{0, 3}},
+	}
+
+	runFindIndexTests(t, allEngines, tests)
+	for _, tt := range tests {
+		runOnEngines(t, allEngines, tt.regex, tt.input, func(t *testing.T, re *regex.Regex) {
+			whole := tt.expected != nil && tt.expected[0] == 0 && tt.expected[1] == len(tt.input)
+			if match, err := re.Check(tt.input); err != nil || match != whole {
+				t.Errorf("Check(%q) on %q = %v, %v, expected %v", tt.input, tt.regex, match, err, whole)
+			}
+		})
+	}
+}
+
+// TestRuneBoundaries tests that no engine starts a match in the middle of a multibyte rune
+func TestRuneBoundaries(t *testing.T) {
+	tests := []indexTest{
+		// \B holds between the bytes of é and €, which are not word characters
+		{`\B`, "aé", []int{3, 3}},
+		{`\B\B`, "a€", []int{4, 4}},
+		{`\b`, "éa", []int{2, 2}},
+		{`\B`, "a\xff", []int{2, 2}},
+		{`\B(é*)`, "aéé", []int{3, 5, 3, 5}},
+		{"[^a]*$", "aé€", []int{1, 6}},
+		{"é*$", "€é", []int{3, 5}},
+	}
+
+	runFindSubmatchIndexTests(t, allEngines, tests)
+}
+
+// TestUnicodeClassMatching tests that \p and \P classes match whole characters of their class
+func TestUnicodeClassMatching(t *testing.T) {
+	tests := []matchTest{
+		{`\p{L}+`, "José", true},
+		{`\p{L}+`, "R2D2", false},
+		{`\p{Lu}\p{Ll}+`, "Émile", true},
+		{`\p{Greek}+`, "λόγος", true},
+		{`\p{Greek}+`, "logos", false},
+		{`[\p{Han}\p{Hiragana}]+`, "日本語のテキスト", false},
+		{`[\p{Han}\p{Hiragana}\p{Katakana}]+`, "日本語のテキスト", true},
+		{`\P{L}+`, "123 !", true},
+		{`[\p{L}\p{M}'-]+`, "O'Brien-Ñúñez", true},
+		{`\p{Nd}+`, "٠١٢", true},
+		{`(?i)\p{Lu}+`, "straße", true},
+	}
+
+	runMatchTests(t, allEngines, tests)
+}
+
+// TestClassSetOperations tests that intersected, subtracted and nested classes match on both engines
+func TestClassSetOperations(t *testing.T) {
+	tests := []matchTest{
+		{"[a-z&&[^aeiou]]+", "rhythm", true},
+		{"[a-z&&[^aeiou]]+", "rhyme", false},
+		{`[\w--\d]+`, "snake_case", true},
+		{`[\w--\d]+`, "v2", false},
+		{`[\p{L}--\p{Latin}]+`, "αβγ", true},
+		{`[\p{L}--\p{Latin}]+`, "abc", false},
+		{"[[a-c][x-z]]+", "axbycz", true},
+		{"[^a-z&&[^x]]", "x", true},
+		{"[+--]+", "+,-", true},
+	}
+
+	runMatchTests(t, allEngines, tests)
+}
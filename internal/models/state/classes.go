@@ -0,0 +1,77 @@
+package state
+
+import "github.com/rubuy-74/pstr/internal/utils"
+
+/*
+ByteClasses splits the 256 byte values into classes of consecutive bytes
+that a pattern never tells apart: every transition of its NFA is taken
+on all the bytes of a class or on none of them. Transitions are keyed
+by the ID of a class instead of a byte, so a range of bytes only needs
+one entry per class it covers, and so do the DFA tables.
+ex.: for [a-z]+, the classes are [\x00-`], [a-z], [{-\x7f] and the
+classes of the bytes above, kept apart by UTF-8 role (see token.ByteClassesOf)
+The invalid bytes of an input (see utils.IsInvalidByte) are in a class
+of their own, the last one, whatever their value, matched by the
+classes holding U+FFFD.
+*/
+type ByteClasses struct {
+	classOf [256]uint8
+	count   int
+}
+
+// Class returns the ID of the class of the byte b, as part of a valid UTF-8 sequence
+func (c *ByteClasses) Class(b byte) uint8 {
+	return c.classOf[b]
+}
+
+// ClassAt returns the ID of the class of the byte at pos in the input
+func (c *ByteClasses) ClassAt(input string, pos int) uint8 {
+	if utils.IsInvalidByte(input, pos) {
+		return c.Invalid()
+	}
+	return c.classOf[input[pos]]
+}
+
+/*
+Invalid returns the ID of the class of the invalid bytes. It fits in a
+byte as the bytes that never occur in valid UTF-8 are never told apart.
+*/
+func (c *ByteClasses) Invalid() uint8 {
+	return uint8(c.count)
+}
+
+// Len returns the number of classes, the invalid bytes included, class IDs going from 0 to Len()-1
+func (c *ByteClasses) Len() int {
+	return c.count + 1
+}
+
+/*
+ByteClassSet collects the byte ranges a pattern matches, to split the
+byte values into classes (see ByteClasses): a class ends right before
+the first byte and at the last byte of every range.
+*/
+type ByteClassSet struct {
+	ends [256]bool
+}
+
+// AddRange adds the range of bytes from begin to end
+func (set *ByteClassSet) AddRange(begin byte, end byte) {
+	if begin > 0 {
+		set.ends[begin-1] = true
+	}
+	set.ends[end] = true
+}
+
+// Classes returns the byte classes of the ranges added so far
+func (set *ByteClassSet) Classes() *ByteClasses {
+	classes := &ByteClasses{}
+	class := 0
+	for b := 0; b < 256; b++ {
+		classes.classOf[b] = uint8(class)
+		if set.ends[b] && b < 255 {
+			class++
+		}
+	}
+	classes.count = class + 1
+	return classes
+}
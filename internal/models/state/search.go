@@ -0,0 +1,171 @@
+package state
+
+import "unicode/utf8"
+
+/*
+thread is a path through the NFA that is being followed by a search:
+the state it is in and its capture slots. Slots 0 and 1 hold the start
+and the end of the match, slots 2n and 2n+1 the ones of group n, and
+-1 marks a slot that was not set.
+*/
+type thread struct {
+	state *State
+	caps  []int
+}
+
+/*
+threadList is the ordered set of threads alive at a position of the input.
+Threads earlier in the list have priority over the later ones, and a
+state is only kept for its first thread.
+*/
+type threadList struct {
+	threads []thread
+	visited map[*State]bool
+}
+
+func newThreadList() *threadList {
+	return &threadList{
+		threads: []thread{},
+		visited: map[*State]bool{},
+	}
+}
+
+/*
+add puts a thread in the list and follows its epsilon transitions
+in order, so the priority of the paths is kept.
+- an explicit stack is used instead of recursion, as in addClosure;
+the epsilon transitions are pushed in reverse so the first one is
+followed first
+- states already in the list are skipped, which also breaks epsilon cycles
+- capture states record pos in a copy of the slots, so that threads
+sharing the slots are not affected
+- epsilon transitions of a state whose assertion fails at pos are not followed
+*/
+func (l *threadList) add(s *State, caps []int, input string, pos int) {
+	stack := []thread{{state: s, caps: caps}}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if l.visited[current.state] {
+			continue
+		}
+		l.visited[current.state] = true
+
+		caps := current.caps
+		if current.state.Save && current.state.Slot < len(caps) {
+			caps = append([]int{}, caps...)
+			caps[current.state.Slot] = pos
+		}
+		l.threads = append(l.threads, thread{state: current.state, caps: caps})
+
+		if !current.state.Assertion.Holds(input, pos) {
+			continue
+		}
+		for i := len(current.state.Epsilon) - 1; i >= 0; i-- {
+			stack = append(stack, thread{state: current.state.Epsilon[i], caps: caps})
+		}
+	}
+}
+
+/*
+FindSubmatchIndex searches the input for the leftmost match of the NFA
+starting at s, Pike VM style, and returns the [start, end) offsets of the
+match followed by the ones of its first groups capture groups, or nil if
+there is no match. A group that did not take part in the match has -1
+offsets, and a group inside a repetition holds its last iteration.
+
+Among the matches starting at the leftmost position, the one found
+first by the NFA (leftmost-first) is returned: for a|ab on "ab" that is "a".
+The search is unanchored: a new thread starting at the initial state is
+added at every rune boundary until a match is found, with a lower
+priority than the threads started before it, so no match starts in the
+middle of a rune. An invalid byte is a rune of its own.
+*/
+func (s *State) FindSubmatchIndex(input string, groups int) []int {
+	return s.search(input, groups, 0, len(input), false)
+}
+
+/*
+FindSubmatchIndexIn returns the offsets of the match of the NFA starting
+at s that FindSubmatchIndex finds, and of its groups, knowing that the
+match is [start, end), as a DFA can tell faster. Only the threads
+starting at start are followed, and none past end, so the time taken
+only depends on the length of the match. The assertions still look at
+the whole input.
+*/
+func (s *State) FindSubmatchIndexIn(input string, groups int, start int, end int) []int {
+	return s.search(input, groups, start, end, true)
+}
+
+/*
+search runs the Pike VM of FindSubmatchIndex over the input from start
+to end, starting a thread at every rune boundary until a match is found,
+or only at start when anchored. nextStart is the next rune boundary.
+*/
+func (s *State) search(input string, groups int, start int, end int, anchored bool) []int {
+	var match []int
+	current := newThreadList()
+	nextStart := start
+
+	for pos := start; ; pos++ {
+		if match == nil && pos == nextStart && (!anchored || pos == start) {
+			if pos < end {
+				_, width := utf8.DecodeRuneInString(input[pos:])
+				nextStart += width
+			}
+			caps := make([]int, 2*(groups+1))
+			for i := range caps {
+				caps[i] = -1
+			}
+			caps[0] = pos
+			current.add(s, caps, input, pos)
+		}
+		// between rune boundaries, no thread may be alive yet
+		if len(current.threads) == 0 && (match != nil || anchored) {
+			break
+		}
+
+		next := newThreadList()
+		for _, th := range current.threads {
+			if th.state.Final {
+				match = append([]int{}, th.caps...)
+				match[1] = pos
+				// the remaining threads have a lower priority
+				break
+			}
+			if pos < end {
+				for _, nextState := range th.state.Transitions[s.Classes.ClassAt(input, pos)] {
+					next.add(nextState, th.caps, input, pos+1)
+				}
+			}
+		}
+
+		if pos >= end {
+			break
+		}
+		current = next
+	}
+
+	return match
+}
+
+/*
+FindIndex searches the input for the leftmost match of the NFA starting
+at s (see FindSubmatchIndex) and returns its [start, end) offsets,
+or nil if there is none.
+*/
+func (s *State) FindIndex(input string) []int {
+	return s.FindSubmatchIndex(input, 0)
+}
+
+/*
+Find searches the input for the leftmost match of the NFA starting at s
+(see FindIndex) and returns the matched text, and whether there was one.
+*/
+func (s *State) Find(input string) (string, bool) {
+	match := s.FindIndex(input)
+	if match == nil {
+		return "", false
+	}
+	return input[match[0]:match[1]], true
+}
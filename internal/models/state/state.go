@@ -4,36 +4,159 @@ import (
 	"github.com/rubuy-74/pstr/internal/utils"
 )
 
+/*
+State is a node of the NFA.
+- Transitions holds the states reached by consuming a byte, keyed by
+the ID of its class (see ByteClasses)
+- Epsilon holds the states reached without consuming input, kept apart
+from Transitions so that every byte value, including 0, can be matched
+- Assertion, when set, makes the Epsilon transitions zero-width
+assertions: they can only be followed where the assertion holds
+- Save marks the state as a capture point: entering it records the
+current position in the capture slot Slot (2n opens group n, 2n+1 closes it)
+- Classes, on the initial state, are the byte classes the transitions
+of the NFA are keyed by
+*/
 type State struct {
 	Initial     bool
 	Final       bool
 	Transitions map[uint8][]*State
+	Epsilon     []*State
+	Assertion   Assertion
+	Save        bool
+	Slot        int
+	Classes     *ByteClasses
 }
 
-// TODO: use multithreading for more performance
-func (s *State) Check(input string, pos int) bool {
-	ch := utils.GetChar(input, pos)
+// Assertion is a zero-width condition on the position in the input
+type Assertion uint8
 
-	if ch == utils.EndOfText && s.Final {
+const (
+	NoAssertion     Assertion = iota
+	TextStart       Assertion = iota
+	TextEnd         Assertion = iota
+	TextEndNewline  Assertion = iota
+	WordBoundary    Assertion = iota
+	NotWordBoundary Assertion = iota
+	LineStart       Assertion = iota
+	LineEnd         Assertion = iota
+)
+
+/*
+Holds reports whether the assertion is true at pos in the input,
+looking at the bytes right before and right after pos:
+- TextStart: pos is at the start of the input
+- TextEnd: pos is at the end of the input
+- TextEndNewline: pos is at the end of the input, or before a '\n' ending it
+- WordBoundary: exactly one of the bytes around pos is a word character,
+the edges of the input counting as non-word characters
+- NotWordBoundary: the opposite of WordBoundary
+- LineStart: pos is at the start of the input or right after a '\n'
+- LineEnd: pos is at the end of the input or right before a '\n'
+*/
+func (a Assertion) Holds(input string, pos int) bool {
+	switch a {
+	case TextStart:
+		return pos <= 0
+	case TextEnd:
+		return pos >= len(input)
+	case TextEndNewline:
+		return pos >= len(input) || pos == len(input)-1 && input[pos] == '\n'
+	case WordBoundary:
+		return isWordBoundary(input, pos)
+	case NotWordBoundary:
+		return !isWordBoundary(input, pos)
+	case LineStart:
+		return pos <= 0 || input[pos-1] == '\n'
+	case LineEnd:
+		return pos >= len(input) || input[pos] == '\n'
+	default:
 		return true
 	}
+}
 
-	if states := s.Transitions[ch]; len(states) > 0 {
-		nextState := states[0]
-		if nextState.Check(input, pos+1) {
-			return true
+// isWordBoundary reports whether pos is between a word and a non-word character
+func isWordBoundary(input string, pos int) bool {
+	before := utils.IsWordChar(utils.GetChar(input, pos-1))
+	after := utils.IsWordChar(utils.GetChar(input, pos))
+	return before != after
+}
+
+/*
+stateSet is the set of states the NFA is in at a position of the input,
+with every state listed once.
+*/
+type stateSet struct {
+	states  []*State
+	visited map[*State]bool
+}
+
+func newStateSet() *stateSet {
+	return &stateSet{
+		states:  []*State{},
+		visited: map[*State]bool{},
+	}
+}
+
+// reset empties the set, keeping its memory for the next position
+func (set *stateSet) reset() {
+	set.states = set.states[:0]
+	clear(set.visited)
+}
+
+/*
+addClosure adds s to the set along with every state reachable from it
+through epsilon transitions at pos, its epsilon closure.
+- an explicit stack is used instead of recursion, so long epsilon
+chains do not grow the call stack
+- states already in the set are skipped, which also breaks epsilon cycles
+- epsilon transitions of a state whose assertion fails at pos are not followed
+*/
+func (set *stateSet) addClosure(s *State, input string, pos int) {
+	stack := []*State{s}
+	for len(stack) > 0 {
+		current := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if set.visited[current] {
+			continue
+		}
+		set.visited[current] = true
+		set.states = append(set.states, current)
+
+		if current.Assertion.Holds(input, pos) {
+			stack = append(stack, current.Epsilon...)
 		}
 	}
+}
 
-	for _, state := range s.Transitions[utils.Epsilon] {
-		if state.Check(input, pos) {
-			return true
+/*
+Check reports whether the whole input is matched by the NFA starting at s.
+The NFA is simulated Thompson style: all the states it can be in are
+followed at once, one byte of the input at a time, so every pattern
+is checked in O(n·m) time, n being the length of the input and m the
+number of states, whatever epsilon cycles it has.
+*/
+func (s *State) Check(input string) bool {
+	current, next := newStateSet(), newStateSet()
+	current.addClosure(s, input, 0)
+
+	for pos := 0; pos < len(input); pos++ {
+		next.reset()
+		for _, state := range current.states {
+			for _, nextState := range state.Transitions[s.Classes.ClassAt(input, pos)] {
+				next.addClosure(nextState, input, pos+1)
+			}
+		}
+		if len(next.states) == 0 {
+			return false
 		}
+		current, next = next, current
+	}
 
-		if ch == utils.StartOfText && state.Check(input, pos+1) {
+	for _, state := range current.states {
+		if state.Final {
 			return true
 		}
 	}
-
 	return false
 }
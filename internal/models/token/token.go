@@ -2,15 +2,31 @@ package token
 
 import (
 	"fmt"
+	"slices"
+	"unicode"
 
 	"github.com/rubuy-74/pstr/internal/models/state"
 	"github.com/rubuy-74/pstr/internal/models/token_type"
 	"github.com/rubuy-74/pstr/internal/utils"
 )
 
+/*
+Token is a node of the parsed regex tree.
+- Value holds the content of the token, depending on its type
+- Span is where the token was parsed from
+- Fold makes a literal or a backreference match regardless of case
+*/
 type Token struct {
 	TokenType token_type.TokenType
 	Value     any
+	Span      Span
+	Fold      bool
+}
+
+// Span is the [Start, End) byte range of the regex a token was parsed from
+type Span struct {
+	Start int
+	End   int
 }
 
 func (t Token) String() string {
@@ -20,26 +36,273 @@ func (t Token) String() string {
 	return fmt.Sprintf("{ %v %v }", t.TokenType, t.Value)
 }
 
+/*
+RepeatPayload is the content of a repetition.
+- Min and Max are the bounds on the number of copies of Token
+- Lazy makes the repetition prefer fewer copies over more
+*/
 type RepeatPayload struct {
 	Min   int
 	Max   int
+	Lazy  bool
 	Token Token
 }
 
 func (rp RepeatPayload) String() string {
+	if rp.Lazy {
+		return fmt.Sprintf("{ %v %v lazy %v }", rp.Min, rp.Max, rp.Token.String())
+	}
 	return fmt.Sprintf("{ %v %v %v }", rp.Min, rp.Max, rp.Token.String())
 }
 
+/*
+GroupPayload is the content of a capturing group.
+- Index is the number of the group, counted from 1 by opening parenthesis
+- Name is the name of a named group, "" for an unnamed one
+- Tokens is the sequence of tokens inside the group
+*/
+type GroupPayload struct {
+	Index  int
+	Name   string
+	Tokens []Token
+}
+
+func (gp GroupPayload) String() string {
+	if gp.Name != "" {
+		return fmt.Sprintf("{ %v<%v> %v }", gp.Index, gp.Name, gp.Tokens)
+	}
+	return fmt.Sprintf("{ %v %v }", gp.Index, gp.Tokens)
+}
+
+/*
+LookaroundPayload is the content of a lookahead or a lookbehind.
+- Negated makes the assertion hold when Tokens do not match
+- Tokens is the sequence of tokens that must (or must not) match
+- MinWidth and MaxWidth bound the length of the text Tokens match,
+which must be bounded for a lookbehind
+*/
+type LookaroundPayload struct {
+	Negated  bool
+	Tokens   []Token
+	MinWidth int
+	MaxWidth int
+}
+
+func (lp LookaroundPayload) String() string {
+	if lp.Negated {
+		return fmt.Sprintf("{ not %v }", lp.Tokens)
+	}
+	return fmt.Sprintf("{ %v }", lp.Tokens)
+}
+
+/*
+BracketPayload is a range of characters, from Begin to End included.
+Characters are Unicode code points, matched in their UTF-8 encoding.
+*/
 type BracketPayload struct {
-	Begin byte
-	End   byte
+	Begin rune
+	End   rune
 }
 
 func (bp BracketPayload) String() string {
 	return fmt.Sprintf("[ '%v' - '%v' ]", string(bp.Begin), string(bp.End))
 }
 
-func (token Token) ToNFA() (*state.State, *state.State) {
+// MaxChar is the highest character a BracketPayload can hold
+const MaxChar = unicode.MaxRune
+
+/*
+NormalizeRanges sorts ranges by their beginning and merges
+the ones that overlap or are adjacent.
+*/
+func NormalizeRanges(ranges []BracketPayload) []BracketPayload {
+	sorted := slices.Clone(ranges)
+	slices.SortFunc(sorted, func(a, b BracketPayload) int {
+		return int(a.Begin) - int(b.Begin)
+	})
+
+	merged := []BracketPayload{}
+	for _, r := range sorted {
+		last := len(merged) - 1
+		if last >= 0 && int(r.Begin) <= int(merged[last].End)+1 {
+			merged[last].End = max(merged[last].End, r.End)
+			continue
+		}
+		merged = append(merged, r)
+	}
+	return merged
+}
+
+/*
+FoldRanges returns the ranges of a class along with the other cases of
+every character they cover, following Unicode simple case folding,
+for a case-insensitive class.
+ex.: [a-c] → [A-Ca-c], [k] → [Kk\u212A] (Kelvin sign)
+*/
+func FoldRanges(ranges []BracketPayload) []BracketPayload {
+	folded := slices.Clone(ranges)
+	for _, r := range ranges {
+		// no character past the last one of the case tables has another case
+		for ch := r.Begin; ch <= min(r.End, maxFoldChar); ch++ {
+			for other := unicode.SimpleFold(ch); other != ch; other = unicode.SimpleFold(other) {
+				folded = append(folded, BracketPayload{Begin: other, End: other})
+			}
+		}
+	}
+	return NormalizeRanges(folded)
+}
+
+// maxFoldChar is above the last character that has another case
+const maxFoldChar = 0x1FFFF
+
+/*
+NegateRanges returns the complement of a class: the ranges of every
+character up to MaxChar that is not covered by the given ranges.
+*/
+func NegateRanges(ranges []BracketPayload) []BracketPayload {
+	negated := []BracketPayload{}
+	next := rune(0)
+	for _, r := range NormalizeRanges(ranges) {
+		if r.Begin > next {
+			negated = append(negated, BracketPayload{Begin: next, End: r.Begin - 1})
+		}
+		next = r.End + 1
+	}
+	if next <= MaxChar {
+		negated = append(negated, BracketPayload{Begin: next, End: MaxChar})
+	}
+	return negated
+}
+
+// IntersectRanges returns the ranges of the characters covered by both a and b
+func IntersectRanges(a []BracketPayload, b []BracketPayload) []BracketPayload {
+	a, b = NormalizeRanges(a), NormalizeRanges(b)
+	intersection := []BracketPayload{}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		begin, end := max(a[i].Begin, b[j].Begin), min(a[i].End, b[j].End)
+		if begin <= end {
+			intersection = append(intersection, BracketPayload{Begin: begin, End: end})
+		}
+		if a[i].End < b[j].End {
+			i++
+		} else {
+			j++
+		}
+	}
+	return intersection
+}
+
+// SubtractRanges returns the ranges of the characters covered by a but not by b
+func SubtractRanges(a []BracketPayload, b []BracketPayload) []BracketPayload {
+	return IntersectRanges(a, NegateRanges(b))
+}
+
+// Assertions maps the token type of each zero-width assertion to the NFA assertion it checks
+var Assertions = map[token_type.TokenType]state.Assertion{
+	token_type.TextStart:       state.TextStart,
+	token_type.TextEnd:         state.TextEnd,
+	token_type.TextEndNewline:  state.TextEndNewline,
+	token_type.LineStart:       state.LineStart,
+	token_type.LineEnd:         state.LineEnd,
+	token_type.WordBoundary:    state.WordBoundary,
+	token_type.NotWordBoundary: state.NotWordBoundary,
+}
+
+/*
+Children returns the tokens directly nested in a token:
+the items of a group or a lookaround, the branches of an alternation
+or the repeated token of a repetition, and nothing for the other tokens.
+*/
+func (token Token) Children() []Token {
+	switch value := token.Value.(type) {
+	case GroupPayload:
+		return value.Tokens
+	case LookaroundPayload:
+		return value.Tokens
+	case RepeatPayload:
+		return []Token{value.Token}
+	case []Token:
+		return value
+	}
+	return nil
+}
+
+/*
+Width returns the bounds on the length of the text a token matches,
+and whether the maximum is bounded at all:
+- characters match the one to four bytes of their UTF-8 encoding
+- assertions match no text
+- groups add up the widths of their items, alternations keep the
+narrowest and widest branches and repetitions multiply the width of
+the repeated token by their bounds
+- backreferences match a text of any length
+*/
+func (token Token) Width() (minimum int, maximum int, bounded bool) {
+	if _, ok := Assertions[token.TokenType]; ok {
+		return 0, 0, true
+	}
+
+	switch token.TokenType {
+	case token_type.Literal:
+		if ch, ok := token.Value.(uint8); ok && token.Fold {
+			minimum, maximum = RangesWidth(FoldRanges([]BracketPayload{{Begin: rune(ch), End: rune(ch)}}))
+			return minimum, maximum, true
+		}
+		return 1, 1, true
+	case token_type.Bracket, token_type.Dot:
+		ranges, _ := token.Value.([]BracketPayload)
+		minimum, maximum = RangesWidth(ranges)
+		return minimum, maximum, true
+	case token_type.Lookahead, token_type.Lookbehind:
+		return 0, 0, true
+	case token_type.Group, token_type.GroupUncaptured, token_type.Atomic:
+		return SequenceWidth(token.Children())
+	case token_type.Or:
+		bounded = true
+		for i, branch := range token.Children() {
+			branchMin, branchMax, branchBounded := branch.Width()
+			if i == 0 || branchMin < minimum {
+				minimum = branchMin
+			}
+			maximum = max(maximum, branchMax)
+			bounded = bounded && branchBounded
+		}
+		return minimum, maximum, bounded
+	case token_type.Repeat:
+		payload, _ := token.Value.(RepeatPayload)
+		innerMin, innerMax, innerBounded := payload.Token.Width()
+		minimum = innerMin * payload.Min
+		if payload.Max == utils.Infinite {
+			return minimum, 0, innerMax == 0 && innerBounded
+		}
+		return minimum, innerMax * payload.Max, innerBounded
+	}
+	return 0, 0, false
+}
+
+// Nullable reports whether a token can match the empty text
+func (token Token) Nullable() bool {
+	minimum, _, _ := token.Width()
+	return minimum == 0
+}
+
+// SequenceWidth returns the bounds on the length of the text a token sequence matches (see Width)
+func SequenceWidth(tokens []Token) (minimum int, maximum int, bounded bool) {
+	bounded = true
+	for _, t := range tokens {
+		tokenMin, tokenMax, tokenBounded := t.Width()
+		minimum += tokenMin
+		maximum += tokenMax
+		bounded = bounded && tokenBounded
+	}
+	return minimum, maximum, bounded
+}
+
+/*
+ToNFA builds the NFA of a token, with transitions keyed by the given
+byte classes (see ByteClassesOf).
+*/
+func (token Token) ToNFA(classes *state.ByteClasses) (*state.State, *state.State) {
 	start := &state.State{
 		Transitions: map[uint8][]*state.State{},
 	}
@@ -48,103 +311,179 @@ func (token Token) ToNFA() (*state.State, *state.State) {
 	}
 
 	switch token.TokenType {
-	case token_type.Group, token_type.GroupUncaptured: // UNTESTED
+	case token_type.Group:
+		if payload, ok := token.Value.(GroupPayload); ok {
+			startInner, endInner := ConcatToNFA(payload.Tokens, classes)
+			start.Save, start.Slot = true, 2*payload.Index
+			end.Save, end.Slot = true, 2*payload.Index+1
+			start.Epsilon = []*state.State{startInner}
+			endInner.Epsilon = append(endInner.Epsilon, end)
+		}
+	case token_type.GroupUncaptured:
 		if values, ok := token.Value.([]Token); ok {
-			start, end = (values[0]).ToNFA()
-			for i := 1; i < len(values); i++ {
-				startNew, endNew := (values[i]).ToNFA()
-				end.Transitions[utils.Epsilon] = append(
-					end.Transitions[utils.Epsilon],
-					startNew,
-				)
-				end = endNew
-			}
+			start, end = ConcatToNFA(values, classes)
 		}
-	case token_type.Bracket:
+	case token_type.Bracket, token_type.Dot:
 		if values, ok := token.Value.([]BracketPayload); ok {
-			for _, bp := range values {
-				for i := bp.Begin; i < bp.End+1; i++ {
-					start.Transitions[i] = []*state.State{end}
-				}
-			}
+			rangesToNFA(values, start, end, classes)
 		}
 	case token_type.Or:
 		if values, ok := token.Value.([]Token); ok {
-			left := values[0]
-			right := values[1]
-			s1, e1 := left.ToNFA()
-			s2, e2 := right.ToNFA()
-
-			start.Transitions[utils.Epsilon] = []*state.State{s1, s2}
-			e1.Transitions[utils.Epsilon] = []*state.State{end}
-			e2.Transitions[utils.Epsilon] = []*state.State{end}
+			for _, branch := range values {
+				s, e := branch.ToNFA(classes)
+				start.Epsilon = append(
+					start.Epsilon,
+					s,
+				)
+				e.Epsilon = append(
+					e.Epsilon,
+					end,
+				)
+			}
 		}
 
 	case token_type.Repeat:
 		if payload, ok := token.Value.(RepeatPayload); ok {
-			if payload.Min == 0 {
-				start.Transitions[utils.Epsilon] = []*state.State{end}
-			}
+			start, end = payload.ToNFA(classes)
+		}
 
-			copyCount := 0
+	case token_type.TextStart, token_type.TextEnd, token_type.TextEndNewline,
+		token_type.LineStart, token_type.LineEnd,
+		token_type.WordBoundary, token_type.NotWordBoundary:
+		start.Assertion = Assertions[token.TokenType]
+		start.Epsilon = []*state.State{end}
 
-			if payload.Max == utils.Infinite {
-				if payload.Min == 0 {
-					copyCount = 1
-				} else {
-					copyCount = payload.Min
-				}
+	case token_type.Literal:
+		if ch, ok := token.Value.(uint8); ok {
+			if token.Fold {
+				rangesToNFA(FoldRanges([]BracketPayload{{Begin: rune(ch), End: rune(ch)}}), start, end, classes)
 			} else {
-				copyCount = payload.Max
+				start.Transitions[classes.Class(ch)] = []*state.State{end}
 			}
+		}
 
-			sOld, eOld := payload.Token.ToNFA()
-			start.Transitions[utils.Epsilon] = append(
-				start.Transitions[utils.Epsilon],
-				sOld,
-			)
+	default:
+		panic("unknown type of token")
+	}
 
-			for i := 2; i <= copyCount; i++ {
-				sNew, eNew := payload.Token.ToNFA()
+	return start, end
+}
 
-				eOld.Transitions[utils.Epsilon] = append(
-					eOld.Transitions[utils.Epsilon],
-					sNew,
-				)
+// ConcatToNFA chains the NFAs of a token sequence with epsilon transitions
+func ConcatToNFA(tokens []Token, classes *state.ByteClasses) (*state.State, *state.State) {
+	start := &state.State{
+		Transitions: map[uint8][]*state.State{},
+	}
+	end := start
 
-				sOld = sNew
-				eOld = eNew
+	for _, t := range tokens {
+		startNew, endNew := t.ToNFA(classes)
+		end.Epsilon = append(
+			end.Epsilon,
+			startNew,
+		)
+		end = endNew
+	}
 
-				// TODO: remove optional to improve performance
-				if i > payload.Min {
-					sNew.Transitions[utils.Epsilon] = append(
-						sNew.Transitions[utils.Epsilon],
-						end,
-					)
-				}
-			}
+	return start, end
+}
 
-			eOld.Transitions[utils.Epsilon] = append(
-				eOld.Transitions[utils.Epsilon],
-				end,
-			)
+/*
+ToNFA builds the NFA of a repetition out of fresh copies of the repeated
+token, so any token (literal, bracket, group...) can be repeated, with
+the shape Go gives it:
+- for a bounded Max, Min mandatory copies chained one after the other,
+then Max-Min optional copies that can each be skipped
+- for an infinite Max and a Min of 0, a copy looping on a choice before
+it, to enter it again or leave, x*, or (x+)? when the token can match
+nothing (see Token.Width)
+- otherwise Min-1 mandatory copies, then a copy that can loop back to
+its own start once it matched, x+
+Entering a copy is tried before skipping it, so repetitions are greedy,
+unless Lazy is set, where skipping is tried first.
+With (x+)?, an iteration matching nothing reaches the start of the copy
+again, where an earlier path already went at the same position, and is
+dropped, rather than the choice after it, so it cannot take the place of
+the path leaving the repetition after that iteration.
+ex.: (?:a*|b)+ and (?:a*|b)* match "" at the start of "b", not "b"
+*/
+func (rp RepeatPayload) ToNFA(classes *state.ByteClasses) (*state.State, *state.State) {
+	start := &state.State{
+		Transitions: map[uint8][]*state.State{},
+	}
+	end := &state.State{
+		Transitions: map[uint8][]*state.State{},
+	}
 
-			if payload.Max == utils.Infinite {
-				end.Transitions[utils.Epsilon] = append(
-					end.Transitions[utils.Epsilon],
-					sOld,
-				)
-			}
-		}
+	minimum := rp.Min
+	if rp.Max == utils.Infinite && minimum > 0 {
+		minimum--
+	}
+	last := start
+	for i := 0; i < minimum; i++ {
+		startNew, endNew := rp.Token.ToNFA(classes)
+		last.Epsilon = append(
+			last.Epsilon,
+			startNew,
+		)
+		last = endNew
+	}
 
-	case token_type.Literal:
-		if ch, ok := token.Value.(uint8); ok {
-			start.Transitions[ch] = []*state.State{end}
+	if rp.Max == utils.Infinite {
+		startNew, endNew := rp.Token.ToNFA(classes)
+		switch {
+		case rp.Min == 0 && !rp.Token.Nullable():
+			loop := &state.State{
+				Transitions: map[uint8][]*state.State{},
+			}
+			last.Epsilon = append(
+				last.Epsilon,
+				loop,
+			)
+			loop.Epsilon = rp.choice(startNew, end)
+			endNew.Epsilon = append(
+				endNew.Epsilon,
+				loop,
+			)
+			return start, end
+		case rp.Min == 0:
+			last.Epsilon = append(
+				last.Epsilon,
+				rp.choice(startNew, end)...,
+			)
+		default:
+			last.Epsilon = append(
+				last.Epsilon,
+				startNew,
+			)
 		}
+		endNew.Epsilon = append(
+			endNew.Epsilon,
+			rp.choice(startNew, end)...,
+		)
+		return start, end
+	}
 
-	default:
-		panic("unknown type of token")
+	for i := minimum; i < rp.Max; i++ {
+		startNew, endNew := rp.Token.ToNFA(classes)
+		last.Epsilon = append(
+			last.Epsilon,
+			rp.choice(startNew, end)...,
+		)
+		last = endNew
 	}
+	last.Epsilon = append(
+		last.Epsilon,
+		end,
+	)
 
 	return start, end
 }
+
+// choice orders entering a copy and skipping it by the greediness of the repetition
+func (rp RepeatPayload) choice(enter *state.State, skip *state.State) []*state.State {
+	if rp.Lazy {
+		return []*state.State{skip, enter}
+	}
+	return []*state.State{enter, skip}
+}
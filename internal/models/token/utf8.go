@@ -0,0 +1,202 @@
+package token
+
+import (
+	"unicode/utf8"
+
+	"github.com/rubuy-74/pstr/internal/models/state"
+	"github.com/rubuy-74/pstr/internal/models/token_type"
+	"github.com/rubuy-74/pstr/internal/utils"
+)
+
+// byteRange is a range of byte values, one position of a UTF-8 sequence
+type byteRange struct {
+	Begin byte
+	End   byte
+}
+
+/*
+utf8Sequences splits the runes from begin to end into UTF-8 byte
+sequences: each sequence is a list of byte ranges, one per byte of the
+encoding, and the runes of the range are exactly the byte strings
+matched by one of the sequences. Surrogates, which cannot be encoded,
+are left out.
+ex.: [U+0080, U+07FF] → [C2-DF][80-BF]
+*/
+func utf8Sequences(begin rune, end rune) [][]byteRange {
+	sequences := [][]byteRange{}
+	var split func(begin rune, end rune)
+	split = func(begin rune, end rune) {
+		if begin > end {
+			return
+		}
+		if end >= surrogateMin && begin <= surrogateMax {
+			split(begin, surrogateMin-1)
+			split(surrogateMax+1, end)
+			return
+		}
+
+		// both ends must be encoded with the same number of bytes
+		for _, last := range []rune{0x7F, 0x7FF, 0xFFFF} {
+			if begin <= last && last < end {
+				split(begin, last)
+				split(last+1, end)
+				return
+			}
+		}
+
+		// past the lead byte, every byte must cover all of its continuation values
+		length := utf8.RuneLen(begin)
+		for i := 1; i < length; i++ {
+			mask := rune(1)<<(6*i) - 1
+			if begin&^mask == end&^mask {
+				continue
+			}
+			if begin&mask != 0 {
+				split(begin, begin|mask)
+				split((begin|mask)+1, end)
+				return
+			}
+			if end&mask != mask {
+				split(begin, end&^mask-1)
+				split(end&^mask, end)
+				return
+			}
+		}
+
+		beginBytes := utf8.AppendRune(nil, begin)
+		endBytes := utf8.AppendRune(nil, end)
+		sequence := make([]byteRange, len(beginBytes))
+		for i := range sequence {
+			sequence[i] = byteRange{Begin: beginBytes[i], End: endBytes[i]}
+		}
+		sequences = append(sequences, sequence)
+	}
+
+	split(begin, min(end, utf8.MaxRune))
+	return sequences
+}
+
+const (
+	surrogateMin = 0xD800
+	surrogateMax = 0xDFFF
+)
+
+/*
+rangesToNFA builds the NFA matching one rune of the given ranges, as a
+chain of byte transitions from start to end for each UTF-8 sequence of
+the ranges (see utf8Sequences), with one transition per byte class
+(see ByteClassesOf) in each byte range of a sequence. Ranges holding
+U+FFFD also match an invalid byte, which stands for it.
+*/
+func rangesToNFA(ranges []BracketPayload, start *state.State, end *state.State, classes *state.ByteClasses) {
+	for _, bp := range ranges {
+		for _, sequence := range utf8Sequences(bp.Begin, bp.End) {
+			from := start
+			for i, br := range sequence {
+				to := end
+				if i < len(sequence)-1 {
+					to = &state.State{
+						Transitions: map[uint8][]*state.State{},
+					}
+				}
+				for class := int(classes.Class(br.Begin)); class <= int(classes.Class(br.End)); class++ {
+					from.Transitions[uint8(class)] = append(from.Transitions[uint8(class)], to)
+				}
+				from = to
+			}
+		}
+		if bp.Begin <= utf8.RuneError && utf8.RuneError <= bp.End {
+			invalid := classes.Invalid()
+			start.Transitions[invalid] = append(start.Transitions[invalid], end)
+		}
+	}
+}
+
+/*
+ByteClassesOf returns the byte classes of the NFA of a token tree (see
+state.ByteClasses), from the bytes its literals match and the byte
+ranges of the UTF-8 sequences of its classes. The ASCII bytes, the
+continuation bytes and the lead bytes of each sequence length are always
+in different classes, so that a DFA search can tell where a rune starts.
+*/
+func ByteClassesOf(tokens []Token) *state.ByteClasses {
+	set := &state.ByteClassSet{}
+	for _, br := range []byteRange{{0x00, 0x7F}, {0x80, 0xBF}, {0xC0, 0xDF}, {0xE0, 0xEF}, {0xF0, 0xFF}} {
+		set.AddRange(br.Begin, br.End)
+	}
+	addByteRanges(set, tokens)
+	return set.Classes()
+}
+
+// addByteRanges adds the byte ranges matched by the tokens of a tree to set
+func addByteRanges(set *state.ByteClassSet, tokens []Token) {
+	for _, t := range tokens {
+		switch t.TokenType {
+		case token_type.Literal:
+			ch, _ := t.Value.(byte)
+			if !t.Fold {
+				set.AddRange(ch, ch)
+				continue
+			}
+			addRuneRanges(set, FoldRanges([]BracketPayload{{Begin: rune(ch), End: rune(ch)}}))
+		case token_type.Bracket, token_type.Dot:
+			ranges, _ := t.Value.([]BracketPayload)
+			addRuneRanges(set, ranges)
+		}
+		addByteRanges(set, t.Children())
+	}
+}
+
+// addRuneRanges adds the byte ranges of the UTF-8 sequences of the given ranges to set
+func addRuneRanges(set *state.ByteClassSet, ranges []BracketPayload) {
+	for _, bp := range ranges {
+		for _, sequence := range utf8Sequences(bp.Begin, bp.End) {
+			for _, br := range sequence {
+				set.AddRange(br.Begin, br.End)
+			}
+		}
+	}
+}
+
+/*
+RangesWidth returns the bounds on the length in bytes of the UTF-8
+encoding of a rune of the given ranges, an invalid byte standing for
+U+FFFD being 1 byte long.
+*/
+func RangesWidth(ranges []BracketPayload) (minimum int, maximum int) {
+	for i, bp := range NormalizeRanges(ranges) {
+		if i == 0 {
+			minimum = runeLen(bp.Begin)
+		}
+		if bp.Begin <= utf8.RuneError && utf8.RuneError <= bp.End {
+			minimum = 1
+		}
+		maximum = max(maximum, runeLen(bp.End))
+	}
+	return minimum, maximum
+}
+
+// runeLen returns the length of the UTF-8 encoding of r, the highest one for a surrogate
+func runeLen(r rune) int {
+	if r >= surrogateMin && r <= surrogateMax {
+		return 3
+	}
+	return max(utf8.RuneLen(r), 1)
+}
+
+/*
+DecodeRune returns the rune at pos in the input and the length of its
+UTF-8 encoding, U+FFFD and 1 for an invalid byte (see
+utils.IsInvalidByte), or a negative length at the end of the input
+and inside a valid UTF-8 sequence, where no rune starts.
+*/
+func DecodeRune(input string, pos int) (rune, int) {
+	if pos >= len(input) {
+		return 0, -1
+	}
+	r, size := utf8.DecodeRuneInString(input[pos:])
+	if r == utf8.RuneError && size == 1 && !utils.IsInvalidByte(input, pos) {
+		return r, -1
+	}
+	return r, size
+}
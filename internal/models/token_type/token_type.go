@@ -11,6 +11,18 @@ const (
 	Repeat          TokenType = iota
 	Literal         TokenType = iota
 	GroupUncaptured TokenType = iota
+	Dot             TokenType = iota
+	TextStart       TokenType = iota
+	TextEnd         TokenType = iota
+	Atomic          TokenType = iota
+	Backref         TokenType = iota
+	Lookahead       TokenType = iota
+	Lookbehind      TokenType = iota
+	TextEndNewline  TokenType = iota
+	WordBoundary    TokenType = iota
+	NotWordBoundary TokenType = iota
+	LineStart       TokenType = iota
+	LineEnd         TokenType = iota
 )
 
 func (t TokenType) String() string {
@@ -27,6 +39,30 @@ func (t TokenType) String() string {
 		return "literal"
 	case GroupUncaptured:
 		return "groupUncaptured"
+	case Dot:
+		return "dot"
+	case TextStart:
+		return "textStart"
+	case TextEnd:
+		return "textEnd"
+	case Atomic:
+		return "atomic"
+	case Backref:
+		return "backref"
+	case Lookahead:
+		return "lookahead"
+	case Lookbehind:
+		return "lookbehind"
+	case TextEndNewline:
+		return "textEndNewline"
+	case WordBoundary:
+		return "wordBoundary"
+	case NotWordBoundary:
+		return "notWordBoundary"
+	case LineStart:
+		return "lineStart"
+	case LineEnd:
+		return "lineEnd"
 	default:
 		return fmt.Sprintf("TokenType(%d)", t)
 	}
@@ -0,0 +1,418 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/rubuy-74/pstr/internal/models/token"
+	"github.com/rubuy-74/pstr/internal/models/token_type"
+)
+
+var (
+	digitRanges = []token.BracketPayload{{Begin: '0', End: '9'}}
+	wordRanges  = []token.BracketPayload{
+		{Begin: '0', End: '9'},
+		{Begin: 'A', End: 'Z'},
+		{Begin: '_', End: '_'},
+		{Begin: 'a', End: 'z'},
+	}
+	spaceRanges = []token.BracketPayload{
+		{Begin: '\t', End: '\n'},
+		{Begin: '\f', End: '\r'},
+		{Begin: ' ', End: ' '},
+	}
+)
+
+/*
+classEntry is a named set of ranges, matched as is or,
+when Negated is set, as its complement.
+*/
+type classEntry struct {
+	Ranges  []token.BracketPayload
+	Negated bool
+}
+
+/*
+expand returns the ranges the class matches. With fold set, the other
+cases of its letters are added before it is complemented, so that
+(?i)\W matches neither k nor K, nor the Kelvin sign K folding to them.
+*/
+func (ce classEntry) expand(fold bool) []token.BracketPayload {
+	ranges := ce.Ranges
+	if fold {
+		ranges = token.FoldRanges(ranges)
+	}
+	if ce.Negated {
+		return token.NegateRanges(ranges)
+	}
+	return ranges
+}
+
+// shorthandClasses maps the letter of a \d \D \w \W \s \S escape to its class
+var shorthandClasses = map[byte]classEntry{
+	'd': {Ranges: digitRanges},
+	'D': {Ranges: digitRanges, Negated: true},
+	'w': {Ranges: wordRanges},
+	'W': {Ranges: wordRanges, Negated: true},
+	's': {Ranges: spaceRanges},
+	'S': {Ranges: spaceRanges, Negated: true},
+}
+
+// posixClasses maps the name of a [:name:] class to its ranges
+var posixClasses = map[string][]token.BracketPayload{
+	"alnum":  {{Begin: '0', End: '9'}, {Begin: 'A', End: 'Z'}, {Begin: 'a', End: 'z'}},
+	"alpha":  {{Begin: 'A', End: 'Z'}, {Begin: 'a', End: 'z'}},
+	"ascii":  {{Begin: 0x00, End: 0x7F}},
+	"blank":  {{Begin: '\t', End: '\t'}, {Begin: ' ', End: ' '}},
+	"cntrl":  {{Begin: 0x00, End: 0x1F}, {Begin: 0x7F, End: 0x7F}},
+	"digit":  digitRanges,
+	"graph":  {{Begin: '!', End: '~'}},
+	"lower":  {{Begin: 'a', End: 'z'}},
+	"print":  {{Begin: ' ', End: '~'}},
+	"punct":  {{Begin: '!', End: '/'}, {Begin: ':', End: '@'}, {Begin: '[', End: '`'}, {Begin: '{', End: '~'}},
+	"space":  {{Begin: '\t', End: '\r'}, {Begin: ' ', End: ' '}},
+	"upper":  {{Begin: 'A', End: 'Z'}},
+	"word":   wordRanges,
+	"xdigit": {{Begin: '0', End: '9'}, {Begin: 'A', End: 'F'}, {Begin: 'a', End: 'f'}},
+}
+
+/*
+processBrackets handles a character class "[ ... ]" (see parseClass)
+and returns its ranges in a single bracket token.
+*/
+func processBrackets(regex []byte, ctx *ParseContext) (token.Token, error) {
+	start := ctx.Pos
+	bpSlice, err := parseClass(regex, ctx)
+	if err != nil {
+		return token.Token{}, err
+	}
+
+	return token.Token{
+		TokenType: token_type.Bracket,
+		Value:     bpSlice,
+		Span:      token.Span{Start: start, End: ctx.Pos},
+	}, nil
+}
+
+/*
+parseClass parses a character class "[ ... ]" and returns its ranges.
+The class is a list of operands, each a union of members
+(see parseClassOperand), joined by set operators that apply from left
+to right:
+- "&&" → intersection, as in [a-z&&[^aeiou]]
+- "--" → subtraction, as in [\w--\d]
+A leading '^' negates the whole class → the ranges are complemented.
+*/
+func parseClass(regex []byte, ctx *ParseContext) ([]token.BracketPayload, error) {
+	start := ctx.Pos
+	ctx.Pos++
+
+	negated := ctx.Pos < len(regex) && regex[ctx.Pos] == '^'
+	if negated {
+		ctx.Pos++
+	}
+
+	bpSlice, err := parseClassOperand(regex, ctx, start, true)
+	if err != nil {
+		return nil, err
+	}
+	for isSetOperator(regex, ctx.Pos) {
+		operator := regex[ctx.Pos]
+		ctx.Pos += 2
+		operand, err := parseClassOperand(regex, ctx, start, false)
+		if err != nil {
+			return nil, err
+		}
+		if operator == '&' {
+			bpSlice = token.IntersectRanges(bpSlice, operand)
+		} else {
+			bpSlice = token.SubtractRanges(bpSlice, operand)
+		}
+	}
+
+	ctx.Pos++
+
+	if negated {
+		bpSlice = token.NegateRanges(bpSlice)
+	}
+	return bpSlice, nil
+}
+
+/*
+parseClassOperand parses the members of a class (see parseClassMember)
+up to its closing ']' or to the next set operator, and returns the
+ranges of all of them.
+- a ']' first in the class, right after the '[' or the '^', is a literal
+- so is a "--" there, not following any operand, so [--/] stays the
+range from '-' to '/'
+- an operand without any member is an error
+- with the FoldCase flag, letters are added in both cases, before any
+set operation or negation
+*/
+func parseClassOperand(regex []byte, ctx *ParseContext, start int, first bool) ([]token.BracketPayload, error) {
+	bpSlice := []token.BracketPayload{}
+	for members := 0; ; members++ {
+		if ctx.Pos >= len(regex) {
+			return nil, fmt.Errorf("missing closing ] for bracket at position %d", start)
+		}
+		leading := first && members == 0
+		if (regex[ctx.Pos] == ']' && !leading) || (isSetOperator(regex, ctx.Pos) && !(leading && regex[ctx.Pos] == '-')) {
+			if members == 0 {
+				return nil, fmt.Errorf("missing operand for class set operation at position %d", ctx.Pos)
+			}
+			break
+		}
+
+		ranges, err := parseClassMember(regex, ctx)
+		if err != nil {
+			return nil, err
+		}
+		bpSlice = append(bpSlice, ranges...)
+	}
+
+	if ctx.Flags&FoldCase != 0 {
+		bpSlice = token.FoldRanges(bpSlice)
+	}
+	return bpSlice, nil
+}
+
+/*
+isSetOperator reports whether a class set operator "&&" or "--" starts
+at pos. Followed by the closing ']', the two characters are literals
+instead, so [+--] stays the range from '+' to '-'.
+*/
+func isSetOperator(regex []byte, pos int) bool {
+	if pos+2 >= len(regex) || regex[pos+2] == ']' {
+		return false
+	}
+	operator := string(regex[pos : pos+2])
+	return operator == "&&" || operator == "--"
+}
+
+/*
+parseClassMember parses one member of a character class:
+- [:name:] → POSIX class, [:^name:] → its complement
+- \d \D \w \W \s \S → shorthand class
+- \p{name} \P{name} → Unicode class (see processUnicodeClass)
+- [ ... ] → nested class (see parseClass)
+- x-y → range between two characters, which can be escapes
+- any other character or escape → single character
+*/
+func parseClassMember(regex []byte, ctx *ParseContext) ([]token.BracketPayload, error) {
+	if isPosixClassStart(regex, ctx.Pos) {
+		return processPosixClass(regex, ctx)
+	}
+	if isShorthandClass(regex, ctx.Pos) {
+		entry := shorthandClasses[regex[ctx.Pos+1]]
+		ctx.Pos += 2
+		return entry.expand(ctx.Flags&FoldCase != 0), nil
+	}
+	if isUnicodeClass(regex, ctx.Pos) {
+		return processUnicodeClass(regex, ctx)
+	}
+	if regex[ctx.Pos] == '[' {
+		return parseClass(regex, ctx)
+	}
+
+	start := ctx.Pos
+	begin, err := parseClassChar(regex, ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Pos+1 < len(regex) && regex[ctx.Pos] == '-' && regex[ctx.Pos+1] != ']' && !isSetOperator(regex, ctx.Pos) {
+		ctx.Pos++
+		if isShorthandClass(regex, ctx.Pos) || isUnicodeClass(regex, ctx.Pos) || regex[ctx.Pos] == '[' {
+			return nil, fmt.Errorf("invalid range end at position %d", ctx.Pos)
+		}
+		end, err := parseClassChar(regex, ctx)
+		if err != nil {
+			return nil, err
+		}
+		if end < begin {
+			return nil, fmt.Errorf("invalid range %c-%c at position %d", begin, end, start)
+		}
+		return []token.BracketPayload{{Begin: begin, End: end}}, nil
+	}
+
+	return []token.BracketPayload{{Begin: begin, End: begin}}, nil
+}
+
+// parseClassChar reads a single, possibly escaped, character of a class
+func parseClassChar(regex []byte, ctx *ParseContext) (rune, error) {
+	if regex[ctx.Pos] == '\\' {
+		return parseEscapedChar(regex, ctx)
+	}
+	return parseRune(regex, ctx)
+}
+
+// isShorthandClass reports whether a \d \D \w \W \s \S escape starts at pos
+func isShorthandClass(regex []byte, pos int) bool {
+	if pos+1 >= len(regex) || regex[pos] != '\\' {
+		return false
+	}
+	_, ok := shorthandClasses[regex[pos+1]]
+	return ok
+}
+
+// isPosixClassStart reports whether a closed [:name:] class starts at pos
+func isPosixClassStart(regex []byte, pos int) bool {
+	return pos+1 < len(regex) &&
+		regex[pos] == '[' &&
+		regex[pos+1] == ':' &&
+		strings.Contains(string(regex[pos+2:]), ":]")
+}
+
+/*
+processPosixClass handles a "[:name:]" class inside brackets,
+where a name starting with '^' stands for the complement.
+*/
+func processPosixClass(regex []byte, ctx *ParseContext) ([]token.BracketPayload, error) {
+	start := ctx.Pos
+	end := start + 2 + strings.Index(string(regex[start+2:]), ":]")
+	name := string(regex[start+2 : end])
+	ctx.Pos = end + 2
+
+	entry := classEntry{}
+	if strings.HasPrefix(name, "^") {
+		entry.Negated = true
+		name = name[1:]
+	}
+
+	ranges, ok := posixClasses[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown POSIX class [:%s:] at position %d", name, start)
+	}
+	entry.Ranges = ranges
+
+	return entry.expand(ctx.Flags&FoldCase != 0), nil
+}
+
+/*
+processShorthandClass handles a \d \D \w \W \s \S escape outside of
+brackets and returns it as a bracket token, with the other cases of its
+characters under the FoldCase flag.
+*/
+func processShorthandClass(regex []byte, ctx *ParseContext) token.Token {
+	start := ctx.Pos
+	entry := shorthandClasses[regex[ctx.Pos+1]]
+	ctx.Pos += 2
+
+	return token.Token{
+		TokenType: token_type.Bracket,
+		Value:     entry.expand(ctx.Flags&FoldCase != 0),
+		Span:      token.Span{Start: start, End: ctx.Pos},
+	}
+}
+
+// isUnicodeClass reports whether a \p or \P escape starts at pos
+func isUnicodeClass(regex []byte, pos int) bool {
+	return pos+1 < len(regex) && regex[pos] == '\\' && (regex[pos+1] == 'p' || regex[pos+1] == 'P')
+}
+
+/*
+processUnicodeClass handles a Unicode class escape and returns its ranges,
+taken from the tables of the unicode package:
+- \pX → one-letter general category, as in \pL
+- \p{name} → general category (L, Lu, Nd, ...), script (Greek, Han, ...) or Any
+- \P{name} or \p{^name} → the complement of the class
+With the FoldCase flag, the other cases of its characters are added
+before it is complemented (see classEntry).
+*/
+func processUnicodeClass(regex []byte, ctx *ParseContext) ([]token.BracketPayload, error) {
+	start := ctx.Pos
+	entry := classEntry{Negated: regex[ctx.Pos+1] == 'P'}
+	ctx.Pos += 2
+	if ctx.Pos >= len(regex) {
+		return nil, fmt.Errorf("missing name for Unicode class at position %d", start)
+	}
+
+	var name string
+	if regex[ctx.Pos] == '{' {
+		end, err := findNextSymbol(regex, ctx.Pos, '}')
+		if err != nil {
+			return nil, fmt.Errorf("missing closing } for Unicode class at position %d", start)
+		}
+		name = string(regex[ctx.Pos+1 : end])
+		ctx.Pos = end + 1
+	} else {
+		name = string(regex[ctx.Pos])
+		ctx.Pos++
+	}
+
+	if strings.HasPrefix(name, "^") {
+		entry.Negated = !entry.Negated
+		name = name[1:]
+	}
+
+	ranges, ok := unicodeClassRanges(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown Unicode class %q at position %d", name, start)
+	}
+	entry.Ranges = ranges
+
+	return entry.expand(ctx.Flags&FoldCase != 0), nil
+}
+
+/*
+unicodeClassRanges returns the ranges of the Unicode class called name,
+and whether there is one. Any stands for every character.
+*/
+func unicodeClassRanges(name string) ([]token.BracketPayload, bool) {
+	if name == "Any" {
+		return []token.BracketPayload{{Begin: 0, End: token.MaxChar}}, true
+	}
+	table, ok := unicode.Categories[name]
+	if !ok {
+		table, ok = unicode.Scripts[name]
+	}
+	if !ok {
+		return nil, false
+	}
+	return tableRanges(table), true
+}
+
+/*
+tableRanges turns a unicode.RangeTable into sorted, merged ranges.
+A range of the table with a stride above 1 covers every stride-th
+character only, so each of its characters becomes a range of its own.
+*/
+func tableRanges(table *unicode.RangeTable) []token.BracketPayload {
+	ranges := []token.BracketPayload{}
+	addRange := func(lo rune, hi rune, stride rune) {
+		if stride == 1 {
+			ranges = append(ranges, token.BracketPayload{Begin: lo, End: hi})
+			return
+		}
+		for ch := lo; ch <= hi; ch += stride {
+			ranges = append(ranges, token.BracketPayload{Begin: ch, End: ch})
+		}
+	}
+
+	for _, r := range table.R16 {
+		addRange(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	for _, r := range table.R32 {
+		addRange(rune(r.Lo), rune(r.Hi), rune(r.Stride))
+	}
+	return token.NormalizeRanges(ranges)
+}
+
+/*
+processUnicodeEscape handles a Unicode class escape outside of brackets
+(see processUnicodeClass) and returns it as a bracket token.
+*/
+func processUnicodeEscape(regex []byte, ctx *ParseContext) (token.Token, error) {
+	start := ctx.Pos
+	ranges, err := processUnicodeClass(regex, ctx)
+	if err != nil {
+		return token.Token{}, err
+	}
+
+	return token.Token{
+		TokenType: token_type.Bracket,
+		Value:     ranges,
+		Span:      token.Span{Start: start, End: ctx.Pos},
+	}, nil
+}
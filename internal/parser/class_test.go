@@ -0,0 +1,307 @@
+package parser
+
+import (
+	"slices"
+	"testing"
+
+	tokenModel "github.com/rubuy-74/pstr/internal/models/token"
+	"github.com/rubuy-74/pstr/internal/models/token_type"
+)
+
+func bracketRanges(t *testing.T, regex string) []tokenModel.BracketPayload {
+	t.Helper()
+	ctx, err := Parse(regex)
+	if err != nil {
+		t.Fatalf("unexpected error for %q: %v", regex, err)
+	}
+	if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != token_type.Bracket {
+		t.Fatalf("expected one bracket token for %q, got %+v", regex, ctx.Tokens)
+	}
+	ranges, ok := ctx.Tokens[0].Value.([]tokenModel.BracketPayload)
+	if !ok {
+		t.Fatalf("invalid token.Value for %q, got %+v", regex, ctx.Tokens[0].Value)
+	}
+	return tokenModel.NormalizeRanges(ranges)
+}
+
+func TestParseShorthandClasses(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected []tokenModel.BracketPayload
+	}{
+		{`\d`, []tokenModel.BracketPayload{{Begin: '0', End: '9'}}},
+		{`\D`, []tokenModel.BracketPayload{{Begin: 0, End: '0' - 1}, {Begin: '9' + 1, End: tokenModel.MaxChar}}},
+		{`\w`, []tokenModel.BracketPayload{{Begin: '0', End: '9'}, {Begin: 'A', End: 'Z'}, {Begin: '_', End: '_'}, {Begin: 'a', End: 'z'}}},
+		{`\s`, []tokenModel.BracketPayload{{Begin: '\t', End: '\n'}, {Begin: '\f', End: '\r'}, {Begin: ' ', End: ' '}}},
+		{`[\d]`, []tokenModel.BracketPayload{{Begin: '0', End: '9'}}},
+		{`[\da-f]`, []tokenModel.BracketPayload{{Begin: '0', End: '9'}, {Begin: 'a', End: 'f'}}},
+		{`[\s\d]`, []tokenModel.BracketPayload{{Begin: '\t', End: '\n'}, {Begin: '\f', End: '\r'}, {Begin: ' ', End: ' '}, {Begin: '0', End: '9'}}},
+	}
+
+	for _, tt := range tests {
+		ranges := bracketRanges(t, tt.regex)
+		if !slices.Equal(ranges, tokenModel.NormalizeRanges(tt.expected)) {
+			t.Errorf("unexpected ranges for %q, got %v", tt.regex, ranges)
+		}
+	}
+}
+
+func TestParsePosixClasses(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected []tokenModel.BracketPayload
+	}{
+		{`[[:alpha:]]`, []tokenModel.BracketPayload{{Begin: 'A', End: 'Z'}, {Begin: 'a', End: 'z'}}},
+		{`[[:digit:]]`, []tokenModel.BracketPayload{{Begin: '0', End: '9'}}},
+		{`[[:space:]]`, []tokenModel.BracketPayload{{Begin: '\t', End: '\r'}, {Begin: ' ', End: ' '}}},
+		{`[[:^digit:]]`, []tokenModel.BracketPayload{{Begin: 0, End: '0' - 1}, {Begin: '9' + 1, End: tokenModel.MaxChar}}},
+		{`[[:upper:][:digit:]_]`, []tokenModel.BracketPayload{{Begin: '0', End: '9'}, {Begin: 'A', End: 'Z'}, {Begin: '_', End: '_'}}},
+	}
+
+	for _, tt := range tests {
+		ranges := bracketRanges(t, tt.regex)
+		if !slices.Equal(ranges, tt.expected) {
+			t.Errorf("unexpected ranges for %q, got %v", tt.regex, ranges)
+		}
+	}
+}
+
+func TestParseBracketMembers(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected []tokenModel.BracketPayload
+	}{
+		{`[abc]`, []tokenModel.BracketPayload{{Begin: 'a', End: 'c'}}},
+		{`[a-zA-Z_]`, []tokenModel.BracketPayload{{Begin: 'A', End: 'Z'}, {Begin: '_', End: '_'}, {Begin: 'a', End: 'z'}}},
+		{`[\]\-]`, []tokenModel.BracketPayload{{Begin: '-', End: '-'}, {Begin: ']', End: ']'}}},
+		{`[\x41-\x43]`, []tokenModel.BracketPayload{{Begin: 'A', End: 'C'}}},
+	}
+
+	for _, tt := range tests {
+		ranges := bracketRanges(t, tt.regex)
+		if !slices.Equal(ranges, tt.expected) {
+			t.Errorf("unexpected ranges for %q, got %v", tt.regex, ranges)
+		}
+	}
+}
+
+func TestParseInvalidClasses(t *testing.T) {
+	tests := []string{
+		`[[:alphabet:]]`,
+		`[a-\d]`,
+		`[\d`,
+		`[\q]`,
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+func TestNegateRanges(t *testing.T) {
+	negated := tokenModel.NegateRanges([]tokenModel.BracketPayload{{Begin: 0, End: 'a'}, {Begin: 'c', End: tokenModel.MaxChar}})
+	if !slices.Equal(negated, []tokenModel.BracketPayload{{Begin: 'b', End: 'b'}}) {
+		t.Errorf("unexpected negated ranges, got %v", negated)
+	}
+
+	full := tokenModel.NegateRanges([]tokenModel.BracketPayload{})
+	if !slices.Equal(full, []tokenModel.BracketPayload{{Begin: 0, End: tokenModel.MaxChar}}) {
+		t.Errorf("unexpected negated ranges, got %v", full)
+	}
+}
+
+func TestIntersectAndSubtractRanges(t *testing.T) {
+	a := []tokenModel.BracketPayload{{Begin: 'a', End: 'z'}, {Begin: '0', End: '9'}}
+	b := []tokenModel.BracketPayload{{Begin: '5', End: 'c'}}
+
+	intersection := tokenModel.IntersectRanges(a, b)
+	if !slices.Equal(intersection, []tokenModel.BracketPayload{{Begin: '5', End: '9'}, {Begin: 'a', End: 'c'}}) {
+		t.Errorf("unexpected intersection, got %v", intersection)
+	}
+
+	difference := tokenModel.SubtractRanges(a, b)
+	if !slices.Equal(difference, []tokenModel.BracketPayload{{Begin: '0', End: '4'}, {Begin: 'd', End: 'z'}}) {
+		t.Errorf("unexpected difference, got %v", difference)
+	}
+}
+
+func TestParseClassSetOperations(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected []tokenModel.BracketPayload
+	}{
+		{`[a-f&&[^aeiou]]`, []tokenModel.BracketPayload{{Begin: 'b', End: 'd'}, {Begin: 'f', End: 'f'}}},
+		{`[\w--\d]`, []tokenModel.BracketPayload{{Begin: 'A', End: 'Z'}, {Begin: '_', End: '_'}, {Begin: 'a', End: 'z'}}},
+		{`[a-z--[b-y]]`, []tokenModel.BracketPayload{{Begin: 'a', End: 'a'}, {Begin: 'z', End: 'z'}}},
+		{`[a-z&&d-f--e]`, []tokenModel.BracketPayload{{Begin: 'd', End: 'd'}, {Begin: 'f', End: 'f'}}},
+		{`[^a-z--b-z]`, []tokenModel.BracketPayload{{Begin: 0, End: 'a' - 1}, {Begin: 'b', End: tokenModel.MaxChar}}},
+		{`[a[0-2]]`, []tokenModel.BracketPayload{{Begin: '0', End: '2'}, {Begin: 'a', End: 'a'}}},
+		{`[[^\D]&&[0-3]]`, []tokenModel.BracketPayload{{Begin: '0', End: '3'}}},
+		{`[a&b]`, []tokenModel.BracketPayload{{Begin: '&', End: '&'}, {Begin: 'a', End: 'b'}}},
+		{`[a&&]`, []tokenModel.BracketPayload{{Begin: '&', End: '&'}, {Begin: 'a', End: 'a'}}},
+		{`[--/]`, []tokenModel.BracketPayload{{Begin: '-', End: '/'}}},
+		{`[^--/]`, []tokenModel.BracketPayload{{Begin: 0, End: '-' - 1}, {Begin: '/' + 1, End: tokenModel.MaxChar}}},
+		{`[--/--.]`, []tokenModel.BracketPayload{{Begin: '-', End: '-'}, {Begin: '/', End: '/'}}},
+		{`(?i)[a-c--b]`, []tokenModel.BracketPayload{{Begin: 'A', End: 'A'}, {Begin: 'C', End: 'C'}, {Begin: 'a', End: 'a'}, {Begin: 'c', End: 'c'}}},
+	}
+
+	for _, tt := range tests {
+		ranges := bracketRanges(t, tt.regex)
+		if !slices.Equal(ranges, tt.expected) {
+			t.Errorf("unexpected ranges for %q, got %v", tt.regex, ranges)
+		}
+	}
+}
+
+func TestParseInvalidClassSetOperations(t *testing.T) {
+	tests := []string{
+		`[&&a]`,
+		`[a&&&&b]`,
+		`[a--[b]`,
+		`[a-[b]]`,
+		`[a&&b`,
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+func TestParseNegatedBrackets(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected []tokenModel.BracketPayload
+	}{
+		{`[^0-9]`, []tokenModel.BracketPayload{{Begin: 0, End: '0' - 1}, {Begin: '9' + 1, End: tokenModel.MaxChar}}},
+		{`[^\x00-\x7f]`, []tokenModel.BracketPayload{{Begin: 0x80, End: tokenModel.MaxChar}}},
+		{`[^\x00-\xff]`, []tokenModel.BracketPayload{{Begin: 0x100, End: tokenModel.MaxChar}}},
+		{`[^\D]`, []tokenModel.BracketPayload{{Begin: '0', End: '9'}}},
+		{`[^]]`, []tokenModel.BracketPayload{{Begin: 0, End: ']' - 1}, {Begin: ']' + 1, End: tokenModel.MaxChar}}},
+	}
+
+	for _, tt := range tests {
+		ranges := bracketRanges(t, tt.regex)
+		if !slices.Equal(ranges, tt.expected) {
+			t.Errorf("unexpected ranges for %q, got %v", tt.regex, ranges)
+		}
+	}
+}
+
+func TestParseBracketLiteralEdges(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected []tokenModel.BracketPayload
+	}{
+		{`[-a]`, []tokenModel.BracketPayload{{Begin: '-', End: '-'}, {Begin: 'a', End: 'a'}}},
+		{`[a-]`, []tokenModel.BracketPayload{{Begin: '-', End: '-'}, {Begin: 'a', End: 'a'}}},
+		{`[]a]`, []tokenModel.BracketPayload{{Begin: ']', End: ']'}, {Begin: 'a', End: 'a'}}},
+		{`[]-a]`, []tokenModel.BracketPayload{{Begin: ']', End: 'a'}}},
+		{`[^-]`, []tokenModel.BracketPayload{{Begin: 0, End: '-' - 1}, {Begin: '-' + 1, End: tokenModel.MaxChar}}},
+		{`[a^]`, []tokenModel.BracketPayload{{Begin: '^', End: '^'}, {Begin: 'a', End: 'a'}}},
+		{`[+--]`, []tokenModel.BracketPayload{{Begin: '+', End: '-'}}},
+	}
+
+	for _, tt := range tests {
+		ranges := bracketRanges(t, tt.regex)
+		if !slices.Equal(ranges, tt.expected) {
+			t.Errorf("unexpected ranges for %q, got %v", tt.regex, ranges)
+		}
+	}
+}
+
+func TestParseUnclosedBrackets(t *testing.T) {
+	tests := []string{`[]`, `[^]`, `[^`, `[a-`}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+func TestParseUnicodeClasses(t *testing.T) {
+	tests := []struct {
+		regex    string
+		included []rune
+		excluded []rune
+	}{
+		{`\pL`, []rune{'a', 'Z', 'é', 'λ', '中'}, []rune{'1', ' ', '_'}},
+		{`\p{Lu}`, []rune{'A', 'É', 'Λ'}, []rune{'a', 'λ', '1'}},
+		{`\p{Nd}`, []rune{'0', '٣'}, []rune{'a', 'Ⅷ'}},
+		{`\p{Greek}`, []rune{'α', 'Ω'}, []rune{'a', 'я'}},
+		{`\P{Greek}`, []rune{'a', 'я'}, []rune{'α', 'Ω'}},
+		{`\p{^Greek}`, []rune{'a'}, []rune{'α'}},
+		{`\p{Any}`, []rune{0, 'a', tokenModel.MaxChar}, []rune{}},
+		{`[\p{Cyrillic}\d]`, []rune{'я', '7'}, []rune{'a'}},
+		{`[^\p{L}]`, []rune{'1', ' '}, []rune{'a', 'é'}},
+		{`(?i)\p{Lu}`, []rune{'A', 'a', 'é'}, []rune{'1'}},
+	}
+
+	for _, tt := range tests {
+		checkClassMembers(t, tt.regex, tt.included, tt.excluded)
+	}
+}
+
+// checkClassMembers checks which characters the class of a regex includes and excludes
+func checkClassMembers(t *testing.T, regex string, included []rune, excluded []rune) {
+	t.Helper()
+	ranges := bracketRanges(t, regex)
+	contains := func(ch rune) bool {
+		return slices.ContainsFunc(ranges, func(bp tokenModel.BracketPayload) bool {
+			return ch >= bp.Begin && ch <= bp.End
+		})
+	}
+	for _, ch := range included {
+		if !contains(ch) {
+			t.Errorf("expected %q to be in %q", ch, regex)
+		}
+	}
+	for _, ch := range excluded {
+		if contains(ch) {
+			t.Errorf("expected %q not to be in %q", ch, regex)
+		}
+	}
+}
+
+// TestParseFoldedClasses tests that classes are folded before they are complemented under (?i)
+func TestParseFoldedClasses(t *testing.T) {
+	tests := []struct {
+		regex    string
+		included []rune
+		excluded []rune
+	}{
+		{`(?i)\w`, []rune{'k', 'K', '\u212a', 'ſ'}, []rune{' '}},
+		{`(?i)\W`, []rune{' '}, []rune{'k', 'K', '\u212a', 'ſ'}},
+		{`(?i)[\W]`, []rune{' '}, []rune{'k', 'K', '\u212a'}},
+		{`(?i)\d`, []rune{'7'}, []rune{'a'}},
+		{`(?i)\p{Lu}`, []rune{'A', 'a', 'ſ'}, []rune{'1'}},
+		{`(?i)[\p{Lu}]`, []rune{'A', 'a'}, []rune{'1'}},
+		{`(?i)\P{Lu}`, []rune{'1', ' '}, []rune{'A', 'a', 'é'}},
+		{`(?i)\p{^Lu}`, []rune{'1'}, []rune{'a'}},
+		{`(?i)[[:^lower:]]`, []rune{'1'}, []rune{'a', 'A', '\u212a'}},
+	}
+
+	for _, tt := range tests {
+		checkClassMembers(t, tt.regex, tt.included, tt.excluded)
+	}
+}
+
+func TestParseInvalidUnicodeClasses(t *testing.T) {
+	tests := []string{
+		`\p`,
+		`\p{Greek`,
+		`\p{Klingon}`,
+		`\p{}`,
+		`[a-\pL]`,
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package parser
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/rubuy-74/pstr/internal/models/token"
+	"github.com/rubuy-74/pstr/internal/models/token_type"
+	"github.com/rubuy-74/pstr/internal/utils"
+)
+
+// metaCharacters are the bytes with a special meaning in a regex
+const metaCharacters = `\.+*?()|[]{}^$`
+
+// controlEscapes maps the letter of a control escape to its byte
+var controlEscapes = map[byte]byte{
+	'a': '\a',
+	'f': '\f',
+	't': '\t',
+	'n': '\n',
+	'r': '\r',
+	'v': '\v',
+}
+
+/*
+assertionEscapes maps the letter of a zero-width assertion escape to its token type:
+- \A → start of the text, \z → end of the text
+- \Z → end of the text, or before a final '\n'
+- \b → word boundary, \B → anything but a word boundary
+*/
+var assertionEscapes = map[byte]token_type.TokenType{
+	'A': token_type.TextStart,
+	'z': token_type.TextEnd,
+	'Z': token_type.TextEndNewline,
+	'b': token_type.WordBoundary,
+	'B': token_type.NotWordBoundary,
+}
+
+/*
+processEscape handles a backslash escape outside of brackets
+and returns it as a literal (see charToken), as a backreference token
+(see processBackref) or as an assertion token (see assertionEscapes).
+*/
+func processEscape(regex []byte, ctx *ParseContext) (token.Token, error) {
+	if isBackref(regex, ctx.Pos) {
+		return processBackref(regex, ctx)
+	}
+	if ctx.Pos+1 < len(regex) {
+		if tokenType, ok := assertionEscapes[regex[ctx.Pos+1]]; ok {
+			ctx.Pos += 2
+			return token.Token{
+				TokenType: tokenType,
+				Span:      token.Span{Start: ctx.Pos - 2, End: ctx.Pos},
+			}, nil
+		}
+	}
+
+	start := ctx.Pos
+	r, err := parseEscapedChar(regex, ctx)
+	if err != nil {
+		return token.Token{}, err
+	}
+	return charToken(ctx, r, start), nil
+}
+
+/*
+parseEscapedChar reads the escape sequence starting at the '\' of the
+current position and returns the character it stands for:
+- any punctuation (metacharacters included) → the punctuation itself
+- \a \f \t \n \r \v → control characters
+- \xHH or \x{H...} → hexadecimal code point, up to U+10FFFF
+- \0, \0N, \0NN, \NN, \NNN → octal character code (up to three digits)
+Letters and digits without a meaning are rejected, so they stay free
+for future escapes, as are backreferences, which only exist outside
+of brackets.
+*/
+func parseEscapedChar(regex []byte, ctx *ParseContext) (rune, error) {
+	start := ctx.Pos
+	ctx.Pos++
+	if ctx.Pos >= len(regex) {
+		return 0, fmt.Errorf("trailing \\ at end of regex")
+	}
+
+	ch := regex[ctx.Pos]
+	ctx.Pos++
+
+	if control, ok := controlEscapes[ch]; ok {
+		return rune(control), nil
+	}
+
+	switch {
+	case ch == 'x':
+		return parseHexEscape(regex, ctx, start)
+	case isBackref(regex, start):
+		return 0, fmt.Errorf("backreference \\%c is not allowed in a character class at position %d", ch, start)
+	case isOctalDigit(ch):
+		end := ctx.Pos
+		for end < len(regex) && end < ctx.Pos+2 && isOctalDigit(regex[end]) {
+			end++
+		}
+		value, _ := strconv.ParseUint(string(regex[ctx.Pos-1:end]), 8, 16)
+		ctx.Pos = end
+		if value > 0xFF {
+			return 0, fmt.Errorf("octal escape out of range at position %d", start)
+		}
+		return rune(value), nil
+	case ch < utf8.RuneSelf && !utils.IsWordChar(ch):
+		return rune(ch), nil
+	}
+
+	return 0, fmt.Errorf("invalid escape sequence \\%c at position %d", ch, start)
+}
+
+/*
+parseHexEscape reads the digits of a \x escape, ctx.Pos being right after the 'x'.
+- \xHH → exactly two hexadecimal digits
+- \x{H...} → one or more hexadecimal digits between braces
+*/
+func parseHexEscape(regex []byte, ctx *ParseContext, start int) (rune, error) {
+	var digits string
+	if ctx.Pos < len(regex) && regex[ctx.Pos] == '{' {
+		end, err := findNextSymbol(regex, ctx.Pos, '}')
+		if err != nil {
+			return 0, fmt.Errorf("missing ending '}' for \\x escape at position %d", start)
+		}
+		digits = string(regex[ctx.Pos+1 : end])
+		ctx.Pos = end + 1
+	} else {
+		if ctx.Pos+2 > len(regex) {
+			return 0, fmt.Errorf("invalid \\x escape at position %d", start)
+		}
+		digits = string(regex[ctx.Pos : ctx.Pos+2])
+		ctx.Pos += 2
+	}
+
+	value, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || len(digits) == 0 {
+		return 0, fmt.Errorf("invalid \\x escape at position %d", start)
+	}
+	if value > unicode.MaxRune || value >= 0xD800 && value <= 0xDFFF {
+		return 0, fmt.Errorf("hexadecimal escape out of range at position %d", start)
+	}
+	return rune(value), nil
+}
+
+/*
+isBackref reports whether a backreference starts at pos:
+- \N, a single digit from 1 to 9 that is not followed by an octal digit,
+which would make it an octal escape
+- \k<name>
+*/
+func isBackref(regex []byte, pos int) bool {
+	if pos+1 >= len(regex) || regex[pos] != '\\' {
+		return false
+	}
+	ch := regex[pos+1]
+	if ch == 'k' {
+		return hasPrefixAt(regex, pos+2, "<")
+	}
+	return ch >= '1' && ch <= '9' && (pos+2 >= len(regex) || !isOctalDigit(regex[pos+2]))
+}
+
+/*
+processBackref handles a backreference, which matches the text last
+matched by a capturing group, and returns it as a token_type.Backref
+token holding the index of the group.
+- \N → group N
+- \k<name> → group named name
+The group must be opened before the backreference.
+*/
+func processBackref(regex []byte, ctx *ParseContext) (token.Token, error) {
+	start := ctx.Pos
+	index := -1
+
+	if regex[ctx.Pos+1] == 'k' {
+		end, err := findNextSymbol(regex, ctx.Pos+2, '>')
+		if err != nil {
+			return token.Token{}, fmt.Errorf("missing closing > for backreference at position %d", start)
+		}
+		name := string(regex[ctx.Pos+3 : end])
+		ctx.Pos = end + 1
+		index = slices.Index(ctx.Names, name)
+		if name == "" || index < 0 {
+			return token.Token{}, fmt.Errorf("backreference to unknown group name %q at position %d", name, start)
+		}
+	} else {
+		index = int(regex[ctx.Pos+1] - '0')
+		ctx.Pos += 2
+		if index > ctx.Groups {
+			return token.Token{}, fmt.Errorf("backreference to unknown group %d at position %d", index, start)
+		}
+	}
+
+	return token.Token{
+		TokenType: token_type.Backref,
+		Value:     index,
+		Span:      token.Span{Start: start, End: ctx.Pos},
+		Fold:      ctx.Flags&FoldCase != 0,
+	}, nil
+}
+
+// isQuoteStart reports whether a \Q literal run starts at pos
+func isQuoteStart(regex []byte, pos int) bool {
+	return pos+1 < len(regex) && regex[pos] == '\\' && regex[pos+1] == 'Q'
+}
+
+/*
+processQuote handles a \Q...\E literal run: every character up to the \E,
+or up to the end of the regex if there is none, is a literal (see charToken),
+metacharacters included.
+*/
+func processQuote(regex []byte, ctx *ParseContext) ([]token.Token, error) {
+	ctx.Pos += 2
+	tokens := []token.Token{}
+	for ctx.Pos < len(regex) {
+		if regex[ctx.Pos] == '\\' && ctx.Pos+1 < len(regex) && regex[ctx.Pos+1] == 'E' {
+			ctx.Pos += 2
+			break
+		}
+		start := ctx.Pos
+		r, err := parseRune(regex, ctx)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, charToken(ctx, r, start))
+	}
+	return tokens, nil
+}
+
+/*
+QuoteMeta returns a regex that matches the input string literally,
+by escaping every metacharacter in it.
+ex.: QuoteMeta("1.5*2") → `1\.5\*2`
+*/
+func QuoteMeta(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		if strings.IndexByte(metaCharacters, s[i]) >= 0 {
+			builder.WriteByte('\\')
+		}
+		builder.WriteByte(s[i])
+	}
+	return builder.String()
+}
+
+func isOctalDigit(ch byte) bool {
+	return ch >= '0' && ch <= '7'
+}
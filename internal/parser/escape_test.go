@@ -0,0 +1,214 @@
+package parser
+
+import (
+	"testing"
+
+	tokenModel "github.com/rubuy-74/pstr/internal/models/token"
+	"github.com/rubuy-74/pstr/internal/models/token_type"
+)
+
+func TestParseEscapes(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected byte
+	}{
+		{`\*`, '*'},
+		{`\(`, '('},
+		{`\.`, '.'},
+		{`\|`, '|'},
+		{`\\`, '\\'},
+		{`\n`, '\n'},
+		{`\t`, '\t'},
+		{`\r`, '\r'},
+		{`\x41`, 'A'},
+		{`\x{42}`, 'B'},
+		{`\x7f`, 0x7F},
+		{`\101`, 'A'},
+		{`\0`, 0},
+		{`\012`, '\n'},
+	}
+
+	for _, tt := range tests {
+		ctx, err := Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.regex, err)
+		}
+		if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != token_type.Literal {
+			t.Fatalf("expected one literal token for %q, got %+v", tt.regex, ctx.Tokens)
+		}
+		if ctx.Tokens[0].Value != tt.expected {
+			t.Errorf("expected %q for %q, got %+v", tt.expected, tt.regex, ctx.Tokens[0].Value)
+		}
+	}
+}
+
+func TestParseNonASCIIEscapes(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected rune
+	}{
+		{`\xff`, 0xFF},
+		{`\x{e9}`, 'é'},
+		{`\x{20ac}`, '€'},
+		{`\x{1f600}`, 0x1F600},
+	}
+
+	for _, tt := range tests {
+		ranges := bracketRanges(t, tt.regex)
+		if len(ranges) != 1 || ranges[0] != (tokenModel.BracketPayload{Begin: tt.expected, End: tt.expected}) {
+			t.Errorf("expected %q for %q, got %v", tt.expected, tt.regex, ranges)
+		}
+	}
+}
+
+func TestParseInvalidEscapes(t *testing.T) {
+	tests := []string{
+		`\`,
+		`a\`,
+		`\q`,
+		`\1`,
+		`\x4`,
+		`\xZZ`,
+		`\x{}`,
+		`\x{110000}`,
+		`\x{d800}`,
+		`\x{41`,
+		`\777`,
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+func TestParseQuote(t *testing.T) {
+	ctx, err := Parse(`a\Q.*(\Eb`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ctx.Tokens) != 5 {
+		t.Fatalf("expected 5 literal tokens, got %+v", ctx.Tokens)
+	}
+	for i, expected := range []byte("a.*(b") {
+		if ctx.Tokens[i].TokenType != token_type.Literal || ctx.Tokens[i].Value != expected {
+			t.Errorf("expected literal %q at %d, got %+v", expected, i, ctx.Tokens[i])
+		}
+	}
+}
+
+func TestParseQuoteWithQuantifier(t *testing.T) {
+	ctx, err := Parse(`\Qab\E*`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ctx.Tokens) != 2 || ctx.Tokens[1].TokenType != token_type.Repeat {
+		t.Fatalf("expected a literal followed by a repeat, got %+v", ctx.Tokens)
+	}
+	payload := ctx.Tokens[1].Value.(tokenModel.RepeatPayload)
+	if payload.Token.Value != byte('b') {
+		t.Errorf("expected the quantifier to repeat 'b', got %+v", payload.Token)
+	}
+}
+
+func TestParseUnterminatedQuote(t *testing.T) {
+	ctx, err := Parse(`\Q|)`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ctx.Tokens) != 2 {
+		t.Errorf("expected 2 literal tokens, got %+v", ctx.Tokens)
+	}
+}
+
+func TestQuoteMeta(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"abc", "abc"},
+		{"1.5*2", `1\.5\*2`},
+		{`(a|b)[c]{d}^$?+\`, `\(a\|b\)\[c\]\{d\}\^\$\?\+\\`},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if quoted := QuoteMeta(tt.input); quoted != tt.expected {
+			t.Errorf("QuoteMeta(%q) = %q, expected %q", tt.input, quoted, tt.expected)
+		}
+	}
+}
+
+func TestParseBackrefs(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected int
+	}{
+		{`(a)\1`, 1},
+		{`(a)(b)(c)(d)(e)(f)(g)(h)(i)\9`, 9},
+		{`(?P<x>a)(?<y>b)\k<y>`, 2},
+		{`(a\1)`, 1},
+	}
+
+	for _, tt := range tests {
+		ctx, err := Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.regex, err)
+		}
+
+		last := ctx.Tokens[len(ctx.Tokens)-1]
+		if last.TokenType == token_type.Group {
+			children := last.Children()
+			last = children[len(children)-1]
+		}
+		if last.TokenType != token_type.Backref || last.Value != tt.expected {
+			t.Errorf("expected a backreference to group %d for %q, got %+v", tt.expected, tt.regex, last)
+		}
+	}
+}
+
+func TestParseInvalidBackrefs(t *testing.T) {
+	tests := []string{
+		`\1(a)`,
+		`(a)\2`,
+		`\k<x>(?<x>a)`,
+		`(?<x>a)\k<y>`,
+		`(?<x>a)\k<x`,
+		`(a)\k<>`,
+		`(a)[\1]`,
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+func TestParseAssertionEscapes(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected token_type.TokenType
+	}{
+		{`\A`, token_type.TextStart},
+		{`\z`, token_type.TextEnd},
+		{`\Z`, token_type.TextEndNewline},
+		{`\b`, token_type.WordBoundary},
+		{`\B`, token_type.NotWordBoundary},
+	}
+
+	for _, tt := range tests {
+		ctx, err := Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.regex, err)
+		}
+		if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != tt.expected {
+			t.Errorf("expected one %v token for %q, got %+v", tt.expected, tt.regex, ctx.Tokens)
+		}
+	}
+
+	if _, err := Parse(`[\b]`); err == nil {
+		t.Errorf("expected error for an assertion inside brackets")
+	}
+}
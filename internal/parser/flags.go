@@ -0,0 +1,137 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+/*
+Flags are matching modes that change how parts of the regex are parsed.
+- DotNL: '.' also matches '\n' (dotall mode)
+- FoldCase: letters match regardless of their case
+- MultiLine: '^' and '$' also match at the start and the end of lines
+- Extended: whitespace and '#' comments up to the end of the line are
+ignored outside of brackets, escape them to match them
+*/
+type Flags uint8
+
+const (
+	DotNL Flags = 1 << iota
+	FoldCase
+	MultiLine
+	Extended
+)
+
+// flagLetters maps the letter of an inline flag to its flag
+var flagLetters = map[byte]Flags{
+	'i': FoldCase,
+	'm': MultiLine,
+	's': DotNL,
+	'x': Extended,
+}
+
+/*
+ParseFlags returns the flags named by a string of inline flag letters
+(see flagLetters), as in "im".
+*/
+func ParseFlags(letters string) (Flags, error) {
+	var flags Flags
+	for i := 0; i < len(letters); i++ {
+		flag, ok := flagLetters[letters[i]]
+		if !ok {
+			return 0, fmt.Errorf("unknown flag %q", letters[i])
+		}
+		flags |= flag
+	}
+	return flags, nil
+}
+
+/*
+flagGroupEnd reports whether an inline flag group starts at pos, that is
+"(?" followed by flag letters and '-', and returns the byte ending it:
+- ')' for flags set until the end of the enclosing group, as in (?i)
+- ':' for flags scoped to a non-capturing group, as in (?i:...)
+- 0 if there is no flag group at pos
+*/
+func flagGroupEnd(regex []byte, pos int) byte {
+	if !hasPrefixAt(regex, pos, "(?") {
+		return 0
+	}
+	for i := pos + 2; i < len(regex); i++ {
+		if regex[i] == ')' || regex[i] == ':' {
+			if i == pos+2 {
+				return 0
+			}
+			return regex[i]
+		}
+		if _, ok := flagLetters[regex[i]]; !ok && regex[i] != '-' {
+			return 0
+		}
+	}
+	return 0
+}
+
+/*
+parseFlagGroup reads the flags of an inline flag group, ctx.Pos being
+at its '(', and returns ctx.Flags changed by them:
+- the letters before a '-' set their flags
+- the letters after a '-' clear their flags
+ctx.Pos is left on the closing ')' or ':'.
+*/
+func parseFlagGroup(regex []byte, ctx *ParseContext) (Flags, error) {
+	start := ctx.Pos
+	flags := ctx.Flags
+	clear := false
+	changed := false
+
+	for ctx.Pos += 2; regex[ctx.Pos] != ')' && regex[ctx.Pos] != ':'; ctx.Pos++ {
+		if regex[ctx.Pos] == '-' {
+			if clear {
+				return 0, fmt.Errorf("invalid flags %s at position %d", flagGroupText(regex, start), start)
+			}
+			clear = true
+			continue
+		}
+
+		flag := flagLetters[regex[ctx.Pos]]
+		if clear {
+			flags &^= flag
+		} else {
+			flags |= flag
+		}
+		changed = true
+	}
+
+	if !changed {
+		return 0, fmt.Errorf("invalid flags %s at position %d", flagGroupText(regex, start), start)
+	}
+	return flags, nil
+}
+
+// flagGroupText returns the text of the flag group starting at start
+func flagGroupText(regex []byte, start int) string {
+	end := start + strings.IndexAny(string(regex[start:]), ":)")
+	return string(regex[start : end+1])
+}
+
+/*
+skipExtended moves past the whitespace and the comments of the regex
+at the current position, when the Extended flag is set.
+*/
+func skipExtended(regex []byte, ctx *ParseContext) {
+	if ctx.Flags&Extended == 0 {
+		return
+	}
+	for ctx.Pos < len(regex) {
+		switch regex[ctx.Pos] {
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+			ctx.Pos++
+		case '#':
+			for ctx.Pos < len(regex) && regex[ctx.Pos] != '\n' {
+				ctx.Pos++
+			}
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package parser
+
+import (
+	"slices"
+	"testing"
+
+	tokenModel "github.com/rubuy-74/pstr/internal/models/token"
+	"github.com/rubuy-74/pstr/internal/models/token_type"
+)
+
+func TestParseInlineFlags(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected []bool
+	}{
+		{"(?i)ab", []bool{true, true}},
+		{"a(?i)b", []bool{false, true}},
+		{"(?i:a)b", []bool{true, false}},
+		{"(?i)a(?-i)b", []bool{true, false}},
+		{"(?i)a(?-i:b)", []bool{true, false}},
+	}
+
+	for _, tt := range tests {
+		ctx, err := Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.regex, err)
+		}
+
+		literals := []tokenModel.Token{}
+		for _, tok := range ctx.Tokens {
+			if tok.TokenType == token_type.GroupUncaptured {
+				literals = append(literals, tok.Children()...)
+				continue
+			}
+			literals = append(literals, tok)
+		}
+		if len(literals) != len(tt.expected) {
+			t.Fatalf("expected %d literals for %q, got %+v", len(tt.expected), tt.regex, ctx.Tokens)
+		}
+		for i, fold := range tt.expected {
+			if literals[i].Fold != fold {
+				t.Errorf("expected fold=%v for literal %d of %q", fold, i, tt.regex)
+			}
+		}
+	}
+}
+
+func TestParseInlineFlagsScope(t *testing.T) {
+	ctx, err := Parse("((?m)^)^")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	inner := ctx.Tokens[0].Children()
+	if len(inner) != 1 || inner[0].TokenType != token_type.LineStart {
+		t.Errorf("expected a line start inside the group, got %+v", inner)
+	}
+	if ctx.Tokens[1].TokenType != token_type.TextStart {
+		t.Errorf("expected the flag to end with its group, got %v", ctx.Tokens[1].TokenType)
+	}
+
+	ctx, err = Parse("(?s:.)(?s).")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ranges := ctx.Tokens[1].Value.([]tokenModel.BracketPayload); len(ranges) != 1 {
+		t.Errorf("expected '.' to match every character with the s flag, got %+v", ranges)
+	}
+}
+
+func TestParseExtended(t *testing.T) {
+	ctx, err := Parse("(?x) a b* # comment\n | c \\  \\#")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != token_type.Or {
+		t.Fatalf("expected one alternation, got %+v", ctx.Tokens)
+	}
+	branches := ctx.Tokens[0].Children()
+	if left := branches[0].Children(); len(left) != 2 || left[1].TokenType != token_type.Repeat {
+		t.Errorf("expected a followed by b*, got %+v", left)
+	}
+	right := branches[1].Children()
+	if len(right) != 3 || right[1].Value != byte(' ') || right[2].Value != byte('#') {
+		t.Errorf("expected c, an escaped space and an escaped #, got %+v", right)
+	}
+
+	ctx, err = Parse("(?x)[ ]")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if ctx.Tokens[0].TokenType != token_type.Bracket {
+		t.Errorf("expected whitespace to be kept inside brackets, got %+v", ctx.Tokens)
+	}
+}
+
+func TestParseInvalidFlags(t *testing.T) {
+	tests := []string{
+		"(?-)a",
+		"(?i-m-s)a",
+		"(?i:a",
+		"(?i)*",
+		"(?q)a",
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+func TestParseFlags(t *testing.T) {
+	tests := []struct {
+		letters     string
+		expected    Flags
+		expectError bool
+	}{
+		{"", 0, false},
+		{"i", FoldCase, false},
+		{"ims", FoldCase | MultiLine | DotNL, false},
+		{"x", Extended, false},
+		{"iq", 0, true},
+		{"-i", 0, true},
+	}
+
+	for _, tt := range tests {
+		flags, err := ParseFlags(tt.letters)
+		if (err != nil) != tt.expectError {
+			t.Errorf("ParseFlags(%q) error = %v, expectError %v", tt.letters, err, tt.expectError)
+		}
+		if err == nil && flags != tt.expected {
+			t.Errorf("ParseFlags(%q) = %v, expected %v", tt.letters, flags, tt.expected)
+		}
+	}
+}
+
+func TestParseWithFoldCase(t *testing.T) {
+	ctx, err := ParseWithFlags("a[b-c](?-i)d", FoldCase)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !ctx.Tokens[0].Fold || ctx.Tokens[2].Fold {
+		t.Errorf("expected only the literal before (?-i) to fold, got %+v", ctx.Tokens)
+	}
+	expected := []tokenModel.BracketPayload{{Begin: 'B', End: 'C'}, {Begin: 'b', End: 'c'}}
+	if ranges := ctx.Tokens[1].Value.([]tokenModel.BracketPayload); !slices.Equal(ranges, expected) {
+		t.Errorf("expected ranges %v, got %v", expected, ranges)
+	}
+}
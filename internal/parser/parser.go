@@ -2,9 +2,9 @@ package parser
 
 import (
 	"fmt"
-	"slices"
 	"strconv"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/rubuy-74/pstr/internal/models/token"
 	"github.com/rubuy-74/pstr/internal/models/token_type"
@@ -13,9 +13,21 @@ import (
 
 type rangeSize int
 
+/*
+ParseContext holds the state of a parse.
+- Pos is the index of the next unread byte of the regex
+- Tokens is the top-level token sequence of the parsed tree
+- Flags are the matching modes the regex is parsed with
+- Groups is the number of capturing groups found so far
+- Names holds the name of each group by index, "" for the unnamed ones
+and for index 0, which stands for the whole match
+*/
 type ParseContext struct {
 	Pos    int
 	Tokens []token.Token
+	Flags  Flags
+	Groups int
+	Names  []string
 }
 
 func (ctx ParseContext) Print() {
@@ -24,113 +36,370 @@ func (ctx ParseContext) Print() {
 }
 
 /*
-process parses one character at the current position of the regex string
-and updates the ParseContext with tokens according to the symbol:
-- '(' : start of a capturing group → delegates to processGroup
-- '[' : start of a character class → delegates to processBrackets
-- '|' : alternation (OR) → delegated to processOr (not yet implemented)
-- '*' : repetition 0 or more times → processRepeat with min=0, max=infinite
-- '+' : repetition 1 or more times → processRepeat with min=1, max=infinite
-- '?' : repetition 0 or 1 → processRepeat with min=0, max=1
-- '{' : repetition with explicit {min,max} → parses bounds with getMinMaxRange
-- default: any other character is treated as a literal token
+The parser is a recursive descent over the following grammar,
+from the lowest to the highest precedence:
+
+	alternation   = concatenation { "|" concatenation }
+	concatenation = repetition { repetition | "(?" flags ")" }
+	repetition    = atom { ( "*" | "+" | "?" | "{" range "}" ) [ "?" | "+" ] }
+	atom          = literal | escape | "." | "^" | "$" | "(" [ prefix ] alternation ")" | "[" class "]"
+	prefix        = "?:" | "?>" | "?=" | "?!" | "?<=" | "?<!" | "?P<" word ">" | "?<" word ">" | "?" flags ":"
+	flags         = { "i" | "m" | "s" | "x" } [ "-" { "i" | "m" | "s" | "x" } ]
+	class         = [ "^" ] members { ( "&&" | "--" ) members }
+	members       = member { member }
+
+Every rule returns tokens that form a tree:
+- Alternate → token_type.Or holding one token_type.GroupUncaptured per branch
+- Concat → token_type.GroupUncaptured holding the sequence of its items
+- Repeat → token_type.Repeat holding a token.RepeatPayload, wrapped
+into a token_type.Atomic token when it is possessive
+- Group → token_type.Group holding a token.GroupPayload with its items,
+token_type.GroupUncaptured holding its items for a "(?:...)" group
+or token_type.Atomic holding its items for a "(?>...)" group
+- Literal → token_type.Literal holding the byte of an ASCII character,
+or token_type.Bracket holding the character for a non-ASCII one
+- Class → token_type.Bracket holding the token.BracketPayload ranges
+- Dot → token_type.Dot holding the token.BracketPayload ranges it matches
+- Anchors → token_type.TextStart and token_type.TextEnd, with no value,
+like the other assertions: token_type.TextEndNewline, token_type.WordBoundary
+and token_type.NotWordBoundary
+- Backreference → token_type.Backref holding the index of its group
+- Lookaround → token_type.Lookahead or token_type.Lookbehind holding
+a token.LookaroundPayload with its items
+Each token carries the span of the regex it was parsed from.
 */
-func process(regex []byte, ctx *ParseContext) error {
-	ch := regex[ctx.Pos]
-	switch ch {
-	case '(':
-		err := processGroup(regex, ctx)
+
+/*
+parseAlternation parses branches separated by '|' until the end of the
+regex or an unmatched ')'.
+- A single branch is returned as its plain token sequence
+- Several branches are wrapped into one token_type.Or token
+- Returns an empty sequence if there is nothing to parse
+*/
+func parseAlternation(regex []byte, ctx *ParseContext) ([]token.Token, error) {
+	start := ctx.Pos
+	branches := []token.Token{}
+	var tokens []token.Token
+
+	for {
+		branchStart := ctx.Pos
+		var err error
+		tokens, err = parseConcatenation(regex, ctx)
 		if err != nil {
-			return err
+			return nil, err
 		}
-	case '[':
-		err := processBrackets(regex, ctx)
-		if err != nil {
-			return err
+
+		if len(tokens) == 0 {
+			if len(branches) > 0 {
+				return nil, fmt.Errorf("missing right operand for | operator at position %d", branchStart-1)
+			}
+			if ctx.Pos < len(regex) && regex[ctx.Pos] == '|' {
+				return nil, fmt.Errorf("missing left operand for | operator at position %d", ctx.Pos)
+			}
+			return tokens, nil
 		}
-	case '|':
-		err := processOr(regex, ctx)
-		if err != nil {
-			return err
+
+		branches = append(branches, token.Token{
+			TokenType: token_type.GroupUncaptured,
+			Value:     tokens,
+			Span:      token.Span{Start: branchStart, End: ctx.Pos},
+		})
+
+		if ctx.Pos >= len(regex) || regex[ctx.Pos] != '|' {
+			break
 		}
+		ctx.Pos++
+	}
 
-	case '*':
-		err := processRepeat(regex, ctx, 0, utils.Infinite)
-		if err != nil {
-			return err
+	if len(branches) == 1 {
+		return tokens, nil
+	}
+
+	return []token.Token{{
+		TokenType: token_type.Or,
+		Value:     branches,
+		Span:      token.Span{Start: start, End: ctx.Pos},
+	}}, nil
+}
+
+/*
+parseConcatenation parses repetitions one after the other until it
+reaches the end of the regex, a '|' or a ')'.
+An inline flag group "(?flags)" on the way changes ctx.Flags for the
+rest of the enclosing group, and produces no token.
+*/
+func parseConcatenation(regex []byte, ctx *ParseContext) ([]token.Token, error) {
+	tokens := []token.Token{}
+	for skipExtended(regex, ctx); ctx.Pos < len(regex) && regex[ctx.Pos] != '|' && regex[ctx.Pos] != ')'; skipExtended(regex, ctx) {
+		if flagGroupEnd(regex, ctx.Pos) == ')' {
+			flags, err := parseFlagGroup(regex, ctx)
+			if err != nil {
+				return nil, err
+			}
+			ctx.Flags = flags
+			ctx.Pos++
+			continue
 		}
 
-	case '+':
-		err := processRepeat(regex, ctx, 1, utils.Infinite)
-		if err != nil {
-			return err
+		if isQuoteStart(regex, ctx.Pos) {
+			literals, err := processQuote(regex, ctx)
+			if err != nil {
+				return nil, err
+			}
+			if len(literals) == 0 {
+				continue
+			}
+			// a quantifier after \Q...\E only applies to the last literal
+			last, err := parseQuantifiers(regex, ctx, literals[len(literals)-1])
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, literals[:len(literals)-1]...)
+			tokens = append(tokens, last)
+			continue
 		}
 
-	case '?':
-		err := processRepeat(regex, ctx, 0, 1)
+		t, err := parseRepetition(regex, ctx)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		tokens = append(tokens, t)
+	}
+	return tokens, nil
+}
 
-	case '{':
-		minimum, maximum, err := getMinMaxRange(regex, ctx)
-		if err != nil {
-			return err
+/*
+parseRepetition parses an atom followed by any number of quantifiers.
+*/
+func parseRepetition(regex []byte, ctx *ParseContext) (token.Token, error) {
+	atom, err := parseAtom(regex, ctx)
+	if err != nil {
+		return token.Token{}, err
+	}
+	return parseQuantifiers(regex, ctx, atom)
+}
+
+/*
+parseQuantifiers wraps an already parsed atom into the quantifiers that follow it:
+- '*' : repetition 0 or more times → min=0, max=infinite
+- '+' : repetition 1 or more times → min=1, max=infinite
+- '?' : repetition 0 or 1 → min=0, max=1
+- '{' : repetition with explicit {min,max} → parses bounds with getMinMaxRange
+A '?' right after a quantifier makes it lazy: *?, +?, ??, {m,n}?
+A '+' right after a quantifier makes it possessive: *+, ++, ?+, {m,n}+,
+it never gives back what it matched, as if in an atomic group: a*+ → (?>a*)
+*/
+func parseQuantifiers(regex []byte, ctx *ParseContext, atom token.Token) (token.Token, error) {
+	var err error
+	for skipExtended(regex, ctx); ctx.Pos < len(regex); skipExtended(regex, ctx) {
+		var minimum, maximum int
+		switch regex[ctx.Pos] {
+		case '*':
+			minimum, maximum = 0, utils.Infinite
+			ctx.Pos++
+		case '+':
+			minimum, maximum = 1, utils.Infinite
+			ctx.Pos++
+		case '?':
+			minimum, maximum = 0, 1
+			ctx.Pos++
+		case '{':
+			minimum, maximum, err = getMinMaxRange(regex, ctx)
+			if err != nil {
+				return token.Token{}, err
+			}
+		default:
+			return atom, nil
 		}
-		err = processRepeat(regex, ctx, minimum, maximum)
-		if err != nil {
-			return err
+		lazy := ctx.Pos < len(regex) && regex[ctx.Pos] == '?'
+		possessive := ctx.Pos < len(regex) && regex[ctx.Pos] == '+'
+		if lazy || possessive {
+			ctx.Pos++
+		}
+		atom = processRepeat(ctx, atom, minimum, maximum, lazy)
+		if possessive {
+			atom = token.Token{
+				TokenType: token_type.Atomic,
+				Value:     []token.Token{atom},
+				Span:      atom.Span,
+			}
+		}
+	}
+
+	return atom, nil
+}
+
+/*
+parseAtom parses the smallest unit of the grammar at the current position:
+- '(' : start of a group → delegates to processGroup
+- '[' : start of a character class → delegates to processBrackets
+- '\' : shorthand class → processShorthandClass, Unicode class →
+processUnicodeEscape, other escapes → processEscape
+- '.' : any character, except '\n' unless the DotNL flag is set
+- '^' / '$' : zero-width assertions of the start / end of the text,
+or of a line with the MultiLine flag
+- a quantifier here has nothing to repeat and is an error
+- default: any other character is treated as a literal (see charToken)
+*/
+func parseAtom(regex []byte, ctx *ParseContext) (token.Token, error) {
+	ch := regex[ctx.Pos]
+	switch ch {
+	case '(':
+		return processGroup(regex, ctx)
+	case '[':
+		return processBrackets(regex, ctx)
+	case '\\':
+		if isShorthandClass(regex, ctx.Pos) {
+			return processShorthandClass(regex, ctx), nil
+		}
+		if isUnicodeClass(regex, ctx.Pos) {
+			return processUnicodeEscape(regex, ctx)
+		}
+		return processEscape(regex, ctx)
+	case '.':
+		ctx.Pos++
+		return token.Token{
+			TokenType: token_type.Dot,
+			Value:     dotRanges(ctx.Flags),
+			Span:      token.Span{Start: ctx.Pos - 1, End: ctx.Pos},
+		}, nil
+	case '^':
+		ctx.Pos++
+		tokenType := token_type.TextStart
+		if ctx.Flags&MultiLine != 0 {
+			tokenType = token_type.LineStart
+		}
+		return token.Token{
+			TokenType: tokenType,
+			Span:      token.Span{Start: ctx.Pos - 1, End: ctx.Pos},
+		}, nil
+	case '$':
+		ctx.Pos++
+		tokenType := token_type.TextEnd
+		if ctx.Flags&MultiLine != 0 {
+			tokenType = token_type.LineEnd
 		}
+		return token.Token{
+			TokenType: tokenType,
+			Span:      token.Span{Start: ctx.Pos - 1, End: ctx.Pos},
+		}, nil
+	case '*', '+', '?', '{':
+		return token.Token{}, fmt.Errorf("missing repeating element for %c at position %d", ch, ctx.Pos)
 	default:
-		ctx.Tokens = append(ctx.Tokens,
-			token.Token{
-				TokenType: token_type.Literal,
-				Value:     ch,
-			})
+		start := ctx.Pos
+		r, err := parseRune(regex, ctx)
+		if err != nil {
+			return token.Token{}, err
+		}
+		return charToken(ctx, r, start), nil
+	}
+}
+
+// parseRune reads the UTF-8 encoded character at the current position
+func parseRune(regex []byte, ctx *ParseContext) (rune, error) {
+	r, size := utf8.DecodeRune(regex[ctx.Pos:])
+	if r == utf8.RuneError && size <= 1 {
+		return 0, fmt.Errorf("invalid UTF-8 at position %d", ctx.Pos)
+	}
+	ctx.Pos += size
+	return r, nil
+}
+
+/*
+charToken returns the token matching a single character, parsed from
+start up to the current position:
+- an ASCII character → token_type.Literal holding its byte, matching
+its other cases too with the FoldCase flag
+- any other character → token_type.Bracket holding the character as
+its only range, with its other cases under the FoldCase flag, as it
+matches the several bytes of its UTF-8 encoding
+*/
+func charToken(ctx *ParseContext, r rune, start int) token.Token {
+	fold := ctx.Flags&FoldCase != 0
+	span := token.Span{Start: start, End: ctx.Pos}
+	if r < utf8.RuneSelf {
+		return token.Token{
+			TokenType: token_type.Literal,
+			Value:     byte(r),
+			Span:      span,
+			Fold:      fold,
+		}
+	}
+
+	ranges := []token.BracketPayload{{Begin: r, End: r}}
+	if fold {
+		ranges = token.FoldRanges(ranges)
+	}
+	return token.Token{
+		TokenType: token_type.Bracket,
+		Value:     ranges,
+		Span:      span,
 	}
-	return nil
 }
 
+// MaxRepeat is the highest bound of a counted repetition, as the NFA holds a copy of the repeated token per count
+const MaxRepeat = 1000
+
 /*
 getMinMaxRange extracts the min and max values from a repetition
-range {m}, {m,}, or {m,n}.
+range {m}, {m,}, or {m,n} and moves past the closing '}'.
 - {m} → fixed repetition count
 - {m,} → min repetitions with no upper bound
-- {m,n} → explicit min and max repetitions
-If parsing fails or invalid, returns infinite for both.
+- {m,n} → explicit min and max repetitions, a missing min being 0: {,n}
+- {} → no repetition at all, {0}
+Bounds must be decimal numbers up to MaxRepeat, and a max lower than
+the min is an error.
 */
 func getMinMaxRange(regex []byte, ctx *ParseContext) (minimum int, maximum int, err error) {
+	start := ctx.Pos
 	newPos, err := findNextSymbol(regex, ctx.Pos, '}')
 	if err != nil {
 		return utils.Infinite, utils.Infinite, fmt.Errorf("missing ending '}'")
 	}
 	rawRange := string(regex[ctx.Pos+1 : newPos])
-	rangeString := strings.FieldsFunc(rawRange, func(r rune) bool {
-		return r == ','
-	})
-
-	ctx.Pos = newPos
-	// TODO: better checking (use macros)
-	if !strings.Contains(rawRange, ",") {
-		value, _ := strconv.Atoi(rawRange)
-		return value, value, nil
-	} else {
-		if len(rangeString) == 1 {
-			if rawRange[0] == ',' {
-				maximum, _ := strconv.Atoi(string(rangeString[0]))
-				return utils.Infinite, maximum, nil
-			} else {
-				minimum, _ := strconv.Atoi(string(rangeString[0]))
-				return minimum, utils.Infinite, nil
-			}
-		}
-		if len(rangeString) == 2 {
-			minimum, _ := strconv.Atoi(string(rangeString[0]))
-			maximum, _ := strconv.Atoi(string(rangeString[1]))
-			return minimum, maximum, nil
+	bounds := strings.Split(rawRange, ",")
+	if len(bounds) > 2 || rawRange == "," {
+		return utils.Infinite, utils.Infinite, fmt.Errorf("invalid range syntax {%v} at position %d", rawRange, start)
+	}
+	ctx.Pos = newPos + 1
+
+	if minimum, err = parseBound(bounds[0], start); err != nil {
+		return utils.Infinite, utils.Infinite, err
+	}
+	if len(bounds) == 1 {
+		return minimum, minimum, nil
+	}
+	if bounds[1] == "" {
+		return minimum, utils.Infinite, nil
+	}
+	if maximum, err = parseBound(bounds[1], start); err != nil {
+		return utils.Infinite, utils.Infinite, err
+	}
+	if maximum < minimum {
+		return utils.Infinite, utils.Infinite, fmt.Errorf("invalid range {%v}: max is lower than min", rawRange)
+	}
+	return minimum, maximum, nil
+}
+
+/*
+parseBound parses a bound of the repetition range at position start,
+0 when it is empty. Fails on anything but decimal digits, and on a
+bound above MaxRepeat.
+*/
+func parseBound(bound string, start int) (int, error) {
+	for i := 0; i < len(bound); i++ {
+		if bound[i] < '0' || bound[i] > '9' {
+			return 0, fmt.Errorf("invalid repetition bound %q at position %d", bound, start)
 		}
 	}
-	return utils.Infinite, utils.Infinite, fmt.Errorf("invalid range syntax")
+	if bound == "" {
+		return 0, nil
+	}
+	value, err := strconv.Atoi(bound)
+	if err != nil || value > MaxRepeat {
+		return 0, fmt.Errorf("repetition bound %v at position %d exceeds the maximum of %d", bound, start, MaxRepeat)
+	}
+	return value, nil
 }
 
 /*
@@ -153,194 +422,257 @@ func findNextSymbol(regex []byte, prevPos int, symbol uint8) (int, error) {
 	return currPos, nil
 }
 
-/*
-chunkBytes splits a byte slice into chunks of given size,
-but only keeps the first and last byte of each chunk.
-Used mainly for bracket expressions with ranges (like a-z).
-*/
-func chunkBytes(data []byte, size int) []token.BracketPayload {
-	numSlices := (len(data) + size - 1) / size
-	subslices := make([]token.BracketPayload, 0, numSlices)
-
-	for i := 0; i < len(data); i += size {
-		end := min(i+size, len(data))
-		subslice := token.BracketPayload{
-			Begin: data[i],
-			End:   data[end-1],
-		}
-		subslices = append(subslices, subslice)
-	}
+// uncapturedGroups maps the prefix of each kind of non-capturing group to its token type
+var uncapturedGroups = map[string]token_type.TokenType{
+	"?:": token_type.GroupUncaptured,
+	"?>": token_type.Atomic,
+}
 
-	return subslices
+// lookarounds maps the prefix of each kind of lookaround to its token type and negation
+var lookarounds = map[string]struct {
+	TokenType token_type.TokenType
+	Negated   bool
+}{
+	"?=":  {TokenType: token_type.Lookahead},
+	"?!":  {TokenType: token_type.Lookahead, Negated: true},
+	"?<=": {TokenType: token_type.Lookbehind},
+	"?<!": {TokenType: token_type.Lookbehind, Negated: true},
 }
 
 /*
-processGroup handles a capturing group "( ... )".
-- Finds the closing ')'
-- Recursively processes the inner substring as a new ParseContext
-- Appends parsed tokens of the group back into the parent context
+processGroup handles a group "( ... )".
+- "(?:" starts a non-capturing group → token_type.GroupUncaptured holding
+the inner tokens, which takes no group number and records no capture
+- "(?>" starts an atomic group → token_type.Atomic holding the inner tokens,
+which never gives back what it matched once it is left
+- "(?=", "(?!", "(?<=" and "(?<!" start a lookaround (see processLookaround)
+- "(?flags:" starts a non-capturing group with its own flags (see parseFlagGroup)
+- any other group is capturing, numbered from 1 in the order of the
+opening parentheses and named when it starts with "?P<name>" or "?<name>"
+(see parseGroupName) → token_type.Group holding the index, name and inner tokens
 */
-func processGroup(regex []byte, ctx *ParseContext) error {
-	ctx.Pos++
-	newPos, err := findNextSymbol(regex, ctx.Pos, ')')
-	if err != nil {
-		return err
-	}
-	if newPos == 1 {
-		return fmt.Errorf("invalid ( in the regex string")
-	}
-	groupRegex := regex[ctx.Pos:newPos]
-	if len(groupRegex) == 0 {
-		return fmt.Errorf("empty group")
+func processGroup(regex []byte, ctx *ParseContext) (token.Token, error) {
+	start := ctx.Pos
+	// flags changed inside the group do not outlive it
+	outerFlags := ctx.Flags
+	defer func() {
+		ctx.Flags = outerFlags
+	}()
+
+	if flagGroupEnd(regex, ctx.Pos) == ':' {
+		flags, err := parseFlagGroup(regex, ctx)
+		if err != nil {
+			return token.Token{}, err
+		}
+		ctx.Flags = flags
+		ctx.Pos++
+		tokens, err := parseGroupBody(regex, ctx, start)
+		if err != nil {
+			return token.Token{}, err
+		}
+		return token.Token{
+			TokenType: token_type.GroupUncaptured,
+			Value:     tokens,
+			Span:      token.Span{Start: start, End: ctx.Pos},
+		}, nil
 	}
-	groupCtx := &ParseContext{
-		Pos:    0,
-		Tokens: []token.Token{},
+	ctx.Pos++
+
+	for prefix, lookaround := range lookarounds {
+		if hasPrefixAt(regex, ctx.Pos, prefix) {
+			ctx.Pos += len(prefix)
+			return processLookaround(regex, ctx, start, lookaround.TokenType, lookaround.Negated)
+		}
 	}
 
-	for groupCtx.Pos < len(groupRegex) {
-		err := process(groupRegex, groupCtx)
+	for prefix, tokenType := range uncapturedGroups {
+		if !hasPrefixAt(regex, ctx.Pos, prefix) {
+			continue
+		}
+		ctx.Pos += len(prefix)
+		tokens, err := parseGroupBody(regex, ctx, start)
 		if err != nil {
-			return err
+			return token.Token{}, err
 		}
-		groupCtx.Pos++
+		return token.Token{
+			TokenType: tokenType,
+			Value:     tokens,
+			Span:      token.Span{Start: start, End: ctx.Pos},
+		}, nil
 	}
 
-	ctx.Pos = newPos
-	ctx.Tokens = append(ctx.Tokens, groupCtx.Tokens...)
+	name, err := parseGroupName(regex, ctx)
+	if err != nil {
+		return token.Token{}, err
+	}
+	ctx.Groups++
+	index := ctx.Groups
+	ctx.Names = append(ctx.Names, name)
+
+	tokens, err := parseGroupBody(regex, ctx, start)
+	if err != nil {
+		return token.Token{}, err
+	}
 
-	return nil
+	return token.Token{
+		TokenType: token_type.Group,
+		Value: token.GroupPayload{
+			Index:  index,
+			Name:   name,
+			Tokens: tokens,
+		},
+		Span: token.Span{Start: start, End: ctx.Pos},
+	}, nil
 }
 
 /*
-processBrackets handles a character class "[ ... ]".
-- Finds the closing ']'
-- If it contains '-', splits into ranges (e.g. a-z)
-- Otherwise, treats as a single range between first and last characters
-- Appends bracket tokens to the context
+processLookaround handles the inside of a lookaround that starts at start,
+a zero-width assertion that the inner tokens match (or, when negated,
+do not match) the text right after the current position for
+token_type.Lookahead, or right before it for token_type.Lookbehind.
+- Returns a token holding a token.LookaroundPayload
+- A lookbehind must match a text of bounded length, so the positions
+it can start at are known
 */
-func processBrackets(regex []byte, ctx *ParseContext) error {
-	ctx.Pos++
-	newPos, err := findNextSymbol(regex, ctx.Pos, ']')
+func processLookaround(regex []byte, ctx *ParseContext, start int, tokenType token_type.TokenType, negated bool) (token.Token, error) {
+	tokens, err := parseGroupBody(regex, ctx, start)
 	if err != nil {
-		return err
-	}
-	insideRegex := regex[ctx.Pos:newPos]
-	if len(insideRegex) == 0 {
-		return fmt.Errorf("empty bracket expression")
+		return token.Token{}, err
 	}
 
-	if newPos == 1 || len(insideRegex) < 2 {
-		return fmt.Errorf("invalid [ in the regex string")
+	minWidth, maxWidth, bounded := token.SequenceWidth(tokens)
+	if tokenType == token_type.Lookbehind && !bounded {
+		return token.Token{}, fmt.Errorf("lookbehind at position %d must match a text of bounded length, without * + {m,} or backreferences", start)
 	}
 
-	bpSlice := []token.BracketPayload{}
+	return token.Token{
+		TokenType: tokenType,
+		Value: token.LookaroundPayload{
+			Negated:  negated,
+			Tokens:   tokens,
+			MinWidth: minWidth,
+			MaxWidth: maxWidth,
+		},
+		Span: token.Span{Start: start, End: ctx.Pos},
+	}, nil
+}
 
-	if slices.Contains(insideRegex, '-') {
-		ranges := chunkBytes(insideRegex, 3)
-		bpSlice = append(bpSlice, ranges...)
-	} else {
-		bpSlice = append(
-			bpSlice,
-			token.BracketPayload{
-				Begin: insideRegex[1],
-				End:   insideRegex[len(insideRegex)-2],
-			},
-		)
+/*
+parseGroupBody parses the inside of a group that starts at start,
+ctx.Pos being past its prefix, and moves past the closing ')'.
+- Recursively parses the inner alternation, so groups can nest
+- Validates that the closing ')' exists and the group is not empty
+*/
+func parseGroupBody(regex []byte, ctx *ParseContext, start int) ([]token.Token, error) {
+	tokens, err := parseAlternation(regex, ctx)
+	if err != nil {
+		return nil, err
 	}
-
-	token := token.Token{
-		TokenType: token_type.Bracket,
-		Value:     bpSlice,
+	if ctx.Pos >= len(regex) {
+		return nil, fmt.Errorf("missing closing ) for group at position %d", start)
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("empty group at position %d", start)
 	}
-	ctx.Tokens = append(ctx.Tokens, token)
+	ctx.Pos++
+	return tokens, nil
+}
 
-	ctx.Pos = newPos
-	return nil
+// hasPrefixAt reports whether the regex continues with prefix at pos
+func hasPrefixAt(regex []byte, pos int, prefix string) bool {
+	return pos <= len(regex) && strings.HasPrefix(string(regex[pos:]), prefix)
 }
 
 /*
- */
-func processOr(regex []byte, ctx *ParseContext) error {
-	rhsContext := &ParseContext{
-		Pos:    ctx.Pos,
-		Tokens: []token.Token{},
+parseGroupName reads the name of a named group, ctx.Pos being right
+after its '(', and moves past the closing '>'.
+- "?P<name>" and "?<name>" → name, made of letters, digits and '_'
+- anything else → "", the group is unnamed and nothing is read
+A name can only be used by one group of the regex.
+*/
+func parseGroupName(regex []byte, ctx *ParseContext) (string, error) {
+	start := ctx.Pos - 1
+	pos := ctx.Pos
+	if pos < len(regex) && regex[pos] == '?' {
+		pos++
+	} else {
+		return "", nil
 	}
-	if len(ctx.Tokens) == 0 {
-		return fmt.Errorf("missing left operand")
+	if pos < len(regex) && regex[pos] == 'P' {
+		pos++
 	}
-
-	if rhsContext.Pos+1 >= len(regex) {
-		return fmt.Errorf("missing right operand")
+	if pos >= len(regex) || regex[pos] != '<' {
+		return "", nil
 	}
 
-	rhsContext.Pos += 1
-	for rhsContext.Pos < len(regex) && regex[rhsContext.Pos] != ')' {
-		err := process(regex, rhsContext)
-		if err != nil {
-			return err
-		}
-		rhsContext.Pos += 1
+	end, err := findNextSymbol(regex, pos, '>')
+	if err != nil {
+		return "", fmt.Errorf("missing closing > for group name at position %d", start)
 	}
-
-	if len(rhsContext.Tokens) == 0 {
-		return fmt.Errorf("missing right operand")
+	name := string(regex[pos+1 : end])
+	if name == "" {
+		return "", fmt.Errorf("empty group name at position %d", start)
 	}
-
-	left := token.Token{
-		TokenType: token_type.GroupUncaptured,
-		Value:     ctx.Tokens,
+	for i := 0; i < len(name); i++ {
+		if !utils.IsWordChar(name[i]) {
+			return "", fmt.Errorf("invalid group name %q at position %d", name, start)
+		}
 	}
-
-	right := token.Token{
-		TokenType: token_type.GroupUncaptured,
-		Value:     rhsContext.Tokens,
+	for _, other := range ctx.Names {
+		if other == name {
+			return "", fmt.Errorf("duplicate group name %q at position %d", name, start)
+		}
 	}
 
-	ctx.Pos = rhsContext.Pos
-
-	ctx.Tokens = []token.Token{{
-		TokenType: token_type.Or,
-		Value:     []token.Token{left, right},
-	}}
-
-	return nil
+	ctx.Pos = end + 1
+	return name, nil
 }
 
 /*
-processRepeat placeholder for handling repetition operators (*, +, ?, {m,n}).
-Currently not implemented.
-- The current processRepeat only allows one token to be repeated
-- It would be nice to be able to repeat a group. ex.: ([a-z]){2}
+processRepeat wraps the repeated token into a token_type.Repeat token.
+The repeated token can be any atom, including groups and brackets, which
+are kept whole so the quantifier applies to the entire sub-expression.
+ex.: ([a-z]){2}, (a|b){2,4}, [0-9]+
 */
-func processRepeat(regex []byte, ctx *ParseContext, min int, max int) error {
-	_ = regex // TODO: the regex variable will be used in the future
-
-	if len(ctx.Tokens) == 0 {
-		return fmt.Errorf("missing repeating element")
-	}
-
-	lastToken := ctx.Tokens[len(ctx.Tokens)-1]
-	ctx.Tokens = ctx.Tokens[:len(ctx.Tokens)-1]
-	ctx.Tokens = append(ctx.Tokens, token.Token{
+func processRepeat(ctx *ParseContext, repeated token.Token, min int, max int, lazy bool) token.Token {
+	return token.Token{
 		TokenType: token_type.Repeat,
 		Value: token.RepeatPayload{
 			Min:   min,
 			Max:   max,
-			Token: lastToken,
+			Lazy:  lazy,
+			Token: repeated,
 		},
-	})
+		Span: token.Span{Start: repeated.Span.Start, End: ctx.Pos},
+	}
+}
 
-	return nil
+/*
+dotRanges returns the ranges matched by '.' under the given flags:
+every character, without '\n' unless DotNL is set.
+*/
+func dotRanges(flags Flags) []token.BracketPayload {
+	if flags&DotNL != 0 {
+		return []token.BracketPayload{{Begin: 0, End: token.MaxChar}}
+	}
+	return token.NegateRanges([]token.BracketPayload{{Begin: '\n', End: '\n'}})
 }
 
 /*
-Parse initializes parsing for a regex string.
-- Converts string to byte slice
-- Iterates through each character, delegating to process
-- Returns final ParseContext with tokens
+Parse initializes parsing for a regex string with no flags set.
 */
 func Parse(regexString string) (*ParseContext, error) {
+	return ParseWithFlags(regexString, 0)
+}
+
+/*
+ParseWithFlags initializes parsing for a regex string.
+- Converts string to byte slice
+- Parses the whole string as an alternation under the given flags
+- Fails if anything is left, which can only be an unmatched ')'
+- Returns final ParseContext with the top-level tokens
+*/
+func ParseWithFlags(regexString string, flags Flags) (*ParseContext, error) {
 	if len(regexString) == 0 {
 		return nil, fmt.Errorf("missing regex string")
 	}
@@ -349,14 +681,18 @@ func Parse(regexString string) (*ParseContext, error) {
 	ctx := &ParseContext{
 		Pos:    0,
 		Tokens: []token.Token{},
+		Flags:  flags,
+		Names:  []string{""},
 	}
-	for ctx.Pos < len(regex) {
-		err := process(regex, ctx)
-		if err != nil {
-			return nil, err
-		}
-		ctx.Pos++
+
+	tokens, err := parseAlternation(regex, ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ctx.Pos < len(regex) {
+		return nil, fmt.Errorf("unmatched ) at position %d", ctx.Pos)
 	}
+	ctx.Tokens = tokens
 
 	return ctx, nil
 }
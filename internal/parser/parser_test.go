@@ -1,10 +1,12 @@
 package parser
 
 import (
+	"slices"
 	"testing"
 
 	tokenModel "github.com/rubuy-74/pstr/internal/models/token"
 	"github.com/rubuy-74/pstr/internal/models/token_type"
+	"github.com/rubuy-74/pstr/internal/utils"
 )
 
 func TestParseLiteral(t *testing.T) {
@@ -24,8 +26,98 @@ func TestParseGroup(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
+	if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != token_type.Group {
+		t.Fatalf("expected one group token, got %+v", ctx.Tokens)
+	}
+
+	group, ok := ctx.Tokens[0].Value.(tokenModel.GroupPayload)
+	if !ok || len(group.Tokens) != 2 {
+		t.Fatalf("expected 2 tokens inside group, got %+v", ctx.Tokens[0].Value)
+	}
+	if group.Index != 1 {
+		t.Errorf("expected group index 1, got %d", group.Index)
+	}
+}
+
+func TestParseNestedGroups(t *testing.T) {
+	ctx, err := Parse("((a|b)c)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != token_type.Group {
+		t.Fatalf("expected one group token, got %+v", ctx.Tokens)
+	}
+
+	outerGroup, ok := ctx.Tokens[0].Value.(tokenModel.GroupPayload)
+	outer := outerGroup.Tokens
+	if !ok || len(outer) != 2 {
+		t.Fatalf("expected 2 tokens inside outer group, got %+v", ctx.Tokens[0].Value)
+	}
+	if outer[0].TokenType != token_type.Group || outer[1].TokenType != token_type.Literal {
+		t.Fatalf("expected group followed by literal, got %+v", outer)
+	}
+
+	innerGroup, ok := outer[0].Value.(tokenModel.GroupPayload)
+	inner := innerGroup.Tokens
+	if !ok || len(inner) != 1 || inner[0].TokenType != token_type.Or {
+		t.Errorf("expected alternation inside inner group, got %+v", outer[0].Value)
+	}
+	if outerGroup.Index != 1 || innerGroup.Index != 2 {
+		t.Errorf("expected groups numbered 1 and 2 by opening parenthesis, got %d and %d", outerGroup.Index, innerGroup.Index)
+	}
+	if ctx.Groups != 2 {
+		t.Errorf("expected 2 groups in the context, got %d", ctx.Groups)
+	}
+}
+
+func TestParseOrMultipleBranches(t *testing.T) {
+	ctx, err := Parse("a|bc|d")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != token_type.Or {
+		t.Fatalf("expected one or token, got %+v", ctx.Tokens)
+	}
+
+	branches, ok := ctx.Tokens[0].Value.([]tokenModel.Token)
+	if !ok || len(branches) != 3 {
+		t.Fatalf("expected three branches, got %+v", ctx.Tokens[0].Value)
+	}
+	middle, ok := branches[1].Value.([]tokenModel.Token)
+	if !ok || len(middle) != 2 {
+		t.Errorf("expected two tokens in the middle branch, got %+v", branches[1].Value)
+	}
+}
+
+func TestParseSpans(t *testing.T) {
+	ctx, err := Parse("x(ab)*")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
 	if len(ctx.Tokens) != 2 {
-		t.Errorf("expected 2 tokens inside group, got %d", len(ctx.Tokens))
+		t.Fatalf("expected two tokens, got %+v", ctx.Tokens)
+	}
+
+	literal := ctx.Tokens[0].Span
+	if literal.Start != 0 || literal.End != 1 {
+		t.Errorf("unexpected literal span, got %+v", literal)
+	}
+
+	repeat := ctx.Tokens[1]
+	if repeat.Span.Start != 1 || repeat.Span.End != 6 {
+		t.Errorf("unexpected repeat span, got %+v", repeat.Span)
+	}
+	group := repeat.Value.(tokenModel.RepeatPayload).Token
+	if group.Span.Start != 1 || group.Span.End != 5 {
+		t.Errorf("unexpected group span, got %+v", group.Span)
+	}
+}
+
+func TestParseUnmatchedClosingParen(t *testing.T) {
+	_, err := Parse("ab)c")
+	if err == nil {
+		t.Errorf("expected error for unmatched )")
 	}
 }
 
@@ -213,7 +305,320 @@ func TestParseRepetitionInfiniteMax(t *testing.T) {
 	if !ok {
 		t.Errorf("invalid token.Value, got %+v", token.Value)
 	}
-	if tokenValue.Min != -1 || tokenValue.Max != 2 {
+	if tokenValue.Min != 0 || tokenValue.Max != 2 {
 		t.Errorf("unexpected min max values, got min:%+v max: %+v", tokenValue.Min, tokenValue.Max)
 	}
 }
+
+func TestParseRepeatTargets(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected token_type.TokenType
+	}{
+		{"(ab)*", token_type.Group},
+		{"(a|b){2,4}", token_type.Group},
+		{"[0-9]+", token_type.Bracket},
+		{"a?", token_type.Literal},
+	}
+
+	for _, tt := range tests {
+		ctx, err := Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.regex, err)
+		}
+		if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != token_type.Repeat {
+			t.Fatalf("expected one repeat token for %q, got %+v", tt.regex, ctx.Tokens)
+		}
+
+		payload, ok := ctx.Tokens[0].Value.(tokenModel.RepeatPayload)
+		if !ok {
+			t.Fatalf("invalid token.Value for %q, got %+v", tt.regex, ctx.Tokens[0].Value)
+		}
+		if payload.Token.TokenType != tt.expected {
+			t.Errorf("expected repeated %v for %q, got %v", tt.expected, tt.regex, payload.Token.TokenType)
+		}
+	}
+}
+
+func TestParseRepetitionMaxLowerThanMin(t *testing.T) {
+	_, err := Parse("a{3,2}")
+	if err == nil {
+		t.Errorf("expected error for a max lower than the min")
+	}
+}
+
+// TestParseInvalidRepetitionBounds tests that bounds that are not numbers or exceed MaxRepeat are rejected
+func TestParseInvalidRepetitionBounds(t *testing.T) {
+	tests := []string{
+		"a{abc}",
+		"a{1,x}",
+		"a{-1}",
+		"a{+2}",
+		"a{ 2}",
+		"a{,}",
+		"a{1,,2}",
+		"a{1001}",
+		"a{2,1001}",
+		"a{99999999999999999999}",
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+
+	for _, regex := range []string{"a{1000}", "a{0,1000}", "a{1000,}"} {
+		if _, err := Parse(regex); err != nil {
+			t.Errorf("unexpected error for %q: %v", regex, err)
+		}
+	}
+}
+
+func TestParseDotAndAnchors(t *testing.T) {
+	ctx, err := Parse("^a.$")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []token_type.TokenType{token_type.TextStart, token_type.Literal, token_type.Dot, token_type.TextEnd}
+	if len(ctx.Tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %+v", len(expected), ctx.Tokens)
+	}
+	for i, tokenType := range expected {
+		if ctx.Tokens[i].TokenType != tokenType {
+			t.Errorf("expected %v at %d, got %v", tokenType, i, ctx.Tokens[i].TokenType)
+		}
+	}
+}
+
+func TestParseDotNL(t *testing.T) {
+	ctx, err := Parse(".")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ranges := ctx.Tokens[0].Value.([]tokenModel.BracketPayload)
+	for _, r := range ranges {
+		if r.Begin <= '\n' && '\n' <= r.End {
+			t.Errorf("expected . to exclude newline, got %v", ranges)
+		}
+	}
+
+	ctx, err = ParseWithFlags(".", DotNL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	ranges = ctx.Tokens[0].Value.([]tokenModel.BracketPayload)
+	if len(ranges) != 1 || ranges[0].Begin != 0 || ranges[0].End != tokenModel.MaxChar {
+		t.Errorf("expected . to match every character with DotNL, got %v", ranges)
+	}
+}
+
+func TestParseNamedGroups(t *testing.T) {
+	ctx, err := Parse("(?P<year>\\d+)-(\\d+)-(?<day>\\d+)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []string{"", "year", "", "day"}
+	if !slices.Equal(ctx.Names, expected) {
+		t.Errorf("expected names %q, got %q", expected, ctx.Names)
+	}
+
+	group, ok := ctx.Tokens[0].Value.(tokenModel.GroupPayload)
+	if !ok || group.Name != "year" || group.Index != 1 {
+		t.Errorf("expected group 1 named year, got %+v", ctx.Tokens[0].Value)
+	}
+}
+
+func TestParseInvalidGroupNames(t *testing.T) {
+	tests := []string{
+		"(?P<>a)",
+		"(?<na-me>a)",
+		"(?P<name",
+		"(?<x>a)(?<x>b)",
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+func TestParseNonCapturingGroups(t *testing.T) {
+	ctx, err := Parse("(?:ab|c)+(d)")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(ctx.Tokens) != 2 || ctx.Tokens[0].TokenType != token_type.Repeat {
+		t.Fatalf("expected a repetition followed by a group, got %+v", ctx.Tokens)
+	}
+	repeated := ctx.Tokens[0].Value.(tokenModel.RepeatPayload).Token
+	if repeated.TokenType != token_type.GroupUncaptured {
+		t.Errorf("expected a non-capturing group to be repeated, got %v", repeated.TokenType)
+	}
+	if repeated.Span != (tokenModel.Span{Start: 0, End: 8}) {
+		t.Errorf("expected the group to span [0, 8), got %+v", repeated.Span)
+	}
+
+	group, ok := ctx.Tokens[1].Value.(tokenModel.GroupPayload)
+	if !ok || group.Index != 1 || ctx.Groups != 1 {
+		t.Errorf("expected the only capturing group to be numbered 1, got %+v (%d groups)", ctx.Tokens[1].Value, ctx.Groups)
+	}
+
+	for _, regex := range []string{"(?:)", "(?:a", "(?:a|)"} {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+func TestParseLazyQuantifiers(t *testing.T) {
+	tests := []struct {
+		regex string
+		min   int
+		max   int
+		lazy  bool
+	}{
+		{"a*?", 0, utils.Infinite, true},
+		{"a+?", 1, utils.Infinite, true},
+		{"a??", 0, 1, true},
+		{"a{2,3}?", 2, 3, true},
+		{"a{2,3}", 2, 3, false},
+	}
+
+	for _, tt := range tests {
+		ctx, err := Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.regex, err)
+		}
+		if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != token_type.Repeat {
+			t.Fatalf("expected one repeat token for %q, got %+v", tt.regex, ctx.Tokens)
+		}
+
+		payload := ctx.Tokens[0].Value.(tokenModel.RepeatPayload)
+		if payload.Min != tt.min || payload.Max != tt.max || payload.Lazy != tt.lazy {
+			t.Errorf("expected {%d %d lazy=%v} for %q, got %+v", tt.min, tt.max, tt.lazy, tt.regex, payload)
+		}
+		if payload.Token.TokenType != token_type.Literal {
+			t.Errorf("expected the lazy quantifier to repeat the literal for %q, got %v", tt.regex, payload.Token.TokenType)
+		}
+	}
+}
+
+func TestParseAtomicAndPossessive(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected token_type.TokenType
+	}{
+		{"(?>ab|a)", token_type.Or},
+		{"a*+", token_type.Repeat},
+		{"a++", token_type.Repeat},
+		{"a?+", token_type.Repeat},
+		{"[0-9]{2,3}+", token_type.Repeat},
+	}
+
+	for _, tt := range tests {
+		ctx, err := Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.regex, err)
+		}
+		if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != token_type.Atomic {
+			t.Fatalf("expected one atomic token for %q, got %+v", tt.regex, ctx.Tokens)
+		}
+
+		inner := ctx.Tokens[0].Children()
+		if len(inner) != 1 || inner[0].TokenType != tt.expected {
+			t.Errorf("expected %v inside the atomic token for %q, got %+v", tt.expected, tt.regex, inner)
+		}
+		if ctx.Groups != 0 {
+			t.Errorf("expected no capturing group for %q, got %d", tt.regex, ctx.Groups)
+		}
+	}
+}
+
+func TestParseLookarounds(t *testing.T) {
+	tests := []struct {
+		regex     string
+		tokenType token_type.TokenType
+		negated   bool
+		minWidth  int
+		maxWidth  int
+	}{
+		{"(?=ab)", token_type.Lookahead, false, 2, 2},
+		{"(?!a+)", token_type.Lookahead, true, 1, 0},
+		{"(?<=a|bc)", token_type.Lookbehind, false, 1, 2},
+		{"(?<![0-9]{2,4}x?)", token_type.Lookbehind, true, 2, 5},
+		{"(?<=(?=a*)b)", token_type.Lookbehind, false, 1, 1},
+	}
+
+	for _, tt := range tests {
+		ctx, err := Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", tt.regex, err)
+		}
+		if len(ctx.Tokens) != 1 || ctx.Tokens[0].TokenType != tt.tokenType {
+			t.Fatalf("expected one %v token for %q, got %+v", tt.tokenType, tt.regex, ctx.Tokens)
+		}
+
+		payload := ctx.Tokens[0].Value.(tokenModel.LookaroundPayload)
+		if payload.Negated != tt.negated {
+			t.Errorf("expected negated=%v for %q", tt.negated, tt.regex)
+		}
+		if tt.tokenType == token_type.Lookbehind && (payload.MinWidth != tt.minWidth || payload.MaxWidth != tt.maxWidth) {
+			t.Errorf("expected width [%d, %d] for %q, got [%d, %d]", tt.minWidth, tt.maxWidth, tt.regex, payload.MinWidth, payload.MaxWidth)
+		}
+	}
+}
+
+func TestParseUnboundedLookbehind(t *testing.T) {
+	tests := []string{
+		"(?<=a*)b",
+		"(?<!a{2,})b",
+		`(a)(?<=\1)`,
+		"(?<=a|b+)c",
+		"(?<=a",
+		"(?<=)",
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
+
+func TestParseUnicode(t *testing.T) {
+	tests := []struct {
+		regex    string
+		expected []tokenModel.BracketPayload
+	}{
+		{"é", []tokenModel.BracketPayload{{Begin: 'é', End: 'é'}}},
+		{"[à-ü]", []tokenModel.BracketPayload{{Begin: 'à', End: 'ü'}}},
+		{"[a€]", []tokenModel.BracketPayload{{Begin: 'a', End: 'a'}, {Begin: '€', End: '€'}}},
+		{"(?i)é", []tokenModel.BracketPayload{{Begin: 'É', End: 'É'}, {Begin: 'é', End: 'é'}}},
+	}
+
+	for _, tt := range tests {
+		ranges := bracketRanges(t, tt.regex)
+		if !slices.Equal(ranges, tt.expected) {
+			t.Errorf("unexpected ranges for %q, got %v", tt.regex, ranges)
+		}
+	}
+}
+
+func TestParseInvalidUTF8(t *testing.T) {
+	tests := []string{
+		"a\xff",
+		"[\xc3]",
+		`\Q` + "\xe2\x82",
+	}
+
+	for _, regex := range tests {
+		if _, err := Parse(regex); err == nil {
+			t.Errorf("expected error for %q", regex)
+		}
+	}
+}
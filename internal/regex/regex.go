@@ -0,0 +1,351 @@
+package regex
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/rubuy-74/pstr/internal/backtrack"
+	"github.com/rubuy-74/pstr/internal/dfa"
+	"github.com/rubuy-74/pstr/internal/models/state"
+	"github.com/rubuy-74/pstr/internal/models/token"
+	"github.com/rubuy-74/pstr/internal/parser"
+	"github.com/rubuy-74/pstr/internal/state_machine"
+)
+
+/*
+Engine is the way a compiled regex is matched.
+- EngineAuto picks EngineNFA, or EngineBacktrack when the regex uses
+a construct that only it supports (see state_machine.CheckSupported)
+- EngineNFA simulates the NFA built from the regex, in time linear in
+the input, but cannot express atomic groups, possessive quantifiers,
+backreferences or lookarounds
+- EngineBacktrack runs the parsed tokens by backtracking, which supports
+every construct, in time linear in the input unless the regex has
+backreferences, whose matches fail with backtrack.ErrStepLimit when
+they take too long
+- EngineDFA turns the NFA into a DFA (see dfa.Compile), slower to compile
+but faster to match, and falls back to EngineLazyDFA when the regex needs
+too many DFA states, or to EngineNFA when it uses an assertion other
+than "^" and "$"
+- EngineLazyDFA only builds the DFA states the inputs need, in a cache of
+bounded size (see dfa.Lazy), and falls back to EngineNFA like EngineDFA
+*/
+type Engine uint8
+
+const (
+	EngineAuto Engine = iota
+	EngineNFA
+	EngineBacktrack
+	EngineDFA
+	EngineLazyDFA
+)
+
+func (e Engine) String() string {
+	switch e {
+	case EngineAuto:
+		return "auto"
+	case EngineNFA:
+		return "nfa"
+	case EngineBacktrack:
+		return "backtrack"
+	case EngineDFA:
+		return "dfa"
+	case EngineLazyDFA:
+		return "lazy-dfa"
+	default:
+		return fmt.Sprintf("Engine(%d)", e)
+	}
+}
+
+/*
+ParseEngine returns the engine with the given name, as returned by String.
+*/
+func ParseEngine(name string) (Engine, error) {
+	for _, engine := range []Engine{EngineAuto, EngineNFA, EngineBacktrack, EngineDFA, EngineLazyDFA} {
+		if engine.String() == name {
+			return engine, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown engine %q", name)
+}
+
+/*
+Options are the settings a regex is compiled with, the zero value
+being the default ones.
+- Flags are the matching modes the regex starts with, as if they were
+set inline at its start (see parser.Flags)
+- Engine is the engine that matches the regex
+- MaxDFAStates is the state limit of EngineDFA and the cache size of
+EngineLazyDFA, dfa.DefaultMaxStates if it is not positive
+*/
+type Options struct {
+	Flags        parser.Flags
+	Engine       Engine
+	MaxDFAStates int
+}
+
+/*
+Regex is a compiled regex, ready to be matched.
+- Engine is the engine that matches the regex, which can differ from
+the one asked for when a DFA engine falls back to another one
+- NFA is the initial state of the NFA built from the regex, for EngineNFA
+and the DFA engines
+- DFA is the DFA built from the NFA, for EngineDFA
+- Lazy is the lazy DFA of the NFA, for EngineLazyDFA, whose Stats tell
+how its state cache is used
+- Tokens is the parsed token tree of the regex, for EngineBacktrack
+- Groups is the number of capturing groups of the regex
+- Names holds the name of each group by index (see SubexpNames)
+*/
+type Regex struct {
+	Engine Engine
+	NFA    *state.State
+	DFA    *dfa.DFA
+	Lazy   *dfa.Lazy
+	Tokens []token.Token
+	Groups int
+	Names  []string
+}
+
+/*
+Compile parses a regex string and prepares it for the NFA engine, or for
+the backtracking engine when the regex uses a construct that only it
+supports (see state_machine.CheckSupported).
+*/
+func Compile(regexString string) (*Regex, error) {
+	return CompileWithOptions(regexString, Options{})
+}
+
+/*
+CompileWithEngine parses a regex string and prepares it for the given engine.
+Fails if the regex uses a construct the engine does not support.
+*/
+func CompileWithEngine(regexString string, engine Engine) (*Regex, error) {
+	return CompileWithOptions(regexString, Options{Engine: engine})
+}
+
+/*
+CompileWithFlags parses a regex string with the given flags set,
+and prepares it like Compile does.
+ex.: CompileWithFlags("content-type", parser.FoldCase) matches "Content-Type"
+*/
+func CompileWithFlags(regexString string, flags parser.Flags) (*Regex, error) {
+	return CompileWithOptions(regexString, Options{Flags: flags})
+}
+
+/*
+CompileWithOptions parses a regex string with the given options
+and prepares it for their engine.
+*/
+func CompileWithOptions(regexString string, options Options) (*Regex, error) {
+	ctx, err := parser.ParseWithFlags(regexString, options.Flags)
+	if err != nil {
+		return nil, err
+	}
+
+	engine := options.Engine
+	if engine == EngineAuto {
+		engine = EngineNFA
+		if state_machine.CheckSupported(ctx.Tokens) != nil {
+			engine = EngineBacktrack
+		}
+	}
+	return compileContext(ctx, engine, options.MaxDFAStates)
+}
+
+// compileContext prepares a parsed regex for the given engine
+func compileContext(ctx *parser.ParseContext, engine Engine, maxDFAStates int) (*Regex, error) {
+	var err error
+	re := &Regex{
+		Engine: engine,
+		Tokens: ctx.Tokens,
+		Groups: ctx.Groups,
+		Names:  ctx.Names,
+	}
+
+	switch engine {
+	case EngineNFA:
+		re.NFA, err = state_machine.ToNFA(ctx)
+		if err != nil {
+			return nil, err
+		}
+	case EngineDFA, EngineLazyDFA:
+		re.NFA, err = state_machine.ToNFA(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if engine == EngineDFA {
+			re.DFA, err = dfa.Compile(re.NFA, maxDFAStates)
+			if errors.Is(err, dfa.ErrStateLimit) {
+				re.Engine = EngineLazyDFA
+			} else if err != nil {
+				re.Engine = EngineNFA
+			}
+		}
+		if re.Engine == EngineLazyDFA {
+			re.Lazy, err = dfa.NewLazy(re.NFA, maxDFAStates)
+			if err != nil {
+				re.Engine = EngineNFA
+			}
+		}
+	case EngineBacktrack:
+	default:
+		return nil, fmt.Errorf("unknown engine %v", engine)
+	}
+
+	return re, nil
+}
+
+/*
+SubexpNames returns the names of the capturing groups of the regex by
+index: the name of group n is at index n, "" for an unnamed group and
+for index 0, the whole match.
+*/
+func (re *Regex) SubexpNames() []string {
+	return re.Names
+}
+
+/*
+SubexpIndex returns the index of the capturing group with the given name,
+or -1 if there is no such group.
+*/
+func (re *Regex) SubexpIndex(name string) int {
+	if name == "" {
+		return -1
+	}
+	for i, other := range re.Names {
+		if other == name {
+			return i
+		}
+	}
+	return -1
+}
+
+/*
+Check reports whether the whole input matches the regex.
+Only EngineBacktrack fails, with backtrack.ErrStepLimit, as all the
+other matching methods do.
+*/
+func (re *Regex) Check(input string) (bool, error) {
+	switch re.Engine {
+	case EngineBacktrack:
+		return backtrack.Match(re.Tokens, input, re.Groups)
+	case EngineDFA:
+		return re.DFA.Check(input), nil
+	case EngineLazyDFA:
+		return re.Lazy.Check(input), nil
+	}
+	return re.NFA.Check(input), nil
+}
+
+/*
+FindIndex returns the [start, end) offsets of the leftmost match
+of the regex in the input, or nil if there is none.
+*/
+func (re *Regex) FindIndex(input string) ([]int, error) {
+	switch re.Engine {
+	case EngineBacktrack:
+		match, err := backtrack.FindSubmatchIndex(re.Tokens, input, re.Groups)
+		if match == nil {
+			return nil, err
+		}
+		return match[:2], nil
+	case EngineDFA:
+		return re.DFA.FindIndex(input), nil
+	case EngineLazyDFA:
+		return re.Lazy.FindIndex(input), nil
+	}
+	return re.NFA.FindIndex(input), nil
+}
+
+/*
+Find returns the text of the leftmost match of the regex in the input,
+and whether there was one.
+*/
+func (re *Regex) Find(input string) (string, bool, error) {
+	match, err := re.FindIndex(input)
+	if match == nil {
+		return "", false, err
+	}
+	return input[match[0]:match[1]], true, nil
+}
+
+/*
+FindSubmatchIndex returns the offsets of the leftmost match of the regex
+in the input and of its capturing groups: the pair at 2n, 2n+1 holds the
+[start, end) offsets of group n, group 0 being the whole match.
+A group that did not take part in the match has -1 offsets.
+Returns nil if there is no match.
+The DFA does not track groups: with the DFA engines, it finds the match,
+and the NFA search then only runs over it to find the groups (see
+state.FindSubmatchIndexIn).
+*/
+func (re *Regex) FindSubmatchIndex(input string) ([]int, error) {
+	switch re.Engine {
+	case EngineBacktrack:
+		return backtrack.FindSubmatchIndex(re.Tokens, input, re.Groups)
+	case EngineDFA, EngineLazyDFA:
+		var match []int
+		if re.Engine == EngineDFA {
+			match = re.DFA.FindIndex(input)
+		} else {
+			match = re.Lazy.FindIndex(input)
+		}
+		if match == nil {
+			return nil, nil
+		}
+		return re.NFA.FindSubmatchIndexIn(input, re.Groups, match[0], match[1]), nil
+	}
+	return re.NFA.FindSubmatchIndex(input, re.Groups), nil
+}
+
+/*
+FindSubmatch returns the text of the leftmost match of the regex in the
+input followed by the text of each capturing group (see FindSubmatchIndex).
+A group that did not take part in the match is empty, use
+FindSubmatchIndex to tell it apart from a group that matched nothing.
+Returns nil if there is no match.
+*/
+func (re *Regex) FindSubmatch(input string) ([]string, error) {
+	index, err := re.FindSubmatchIndex(input)
+	if index == nil {
+		return nil, err
+	}
+
+	submatches := make([]string, re.Groups+1)
+	for i := range submatches {
+		if index[2*i] >= 0 {
+			submatches[i] = input[index[2*i]:index[2*i+1]]
+		}
+	}
+	return submatches, nil
+}
+
+/*
+FindSubmatchMap returns the text matched by each named capturing group
+of the leftmost match of the regex in the input, by name. A named group
+that did not take part in the match is left out of the map.
+Returns nil if there is no match.
+*/
+func (re *Regex) FindSubmatchMap(input string) (map[string]string, error) {
+	index, err := re.FindSubmatchIndex(input)
+	if index == nil {
+		return nil, err
+	}
+	return re.SubmatchMap(input, index), nil
+}
+
+/*
+SubmatchMap returns the text of each named capturing group of a match
+of the regex in the input by name, from the offsets given by
+FindSubmatchIndex (see FindSubmatchMap).
+*/
+func (re *Regex) SubmatchMap(input string, index []int) map[string]string {
+	submatches := map[string]string{}
+	for i, name := range re.Names {
+		if name != "" && index[2*i] >= 0 {
+			submatches[name] = input[index[2*i]:index[2*i+1]]
+		}
+	}
+	return submatches
+}
@@ -4,8 +4,9 @@ import (
 	"fmt"
 
 	"github.com/rubuy-74/pstr/internal/models/state"
+	"github.com/rubuy-74/pstr/internal/models/token"
+	"github.com/rubuy-74/pstr/internal/models/token_type"
 	"github.com/rubuy-74/pstr/internal/parser"
-	"github.com/rubuy-74/pstr/internal/utils"
 )
 
 // TODO: Fix ToNFA() - Array bounds crash when no tokens exist.
@@ -15,19 +16,18 @@ func ToNFA(ctx *parser.ParseContext) (*state.State, error) {
 	if len(ctx.Tokens) == 0 {
 		return nil, fmt.Errorf("missing tokens to create NFA")
 	}
-	startOld, endOld := (ctx.Tokens[0]).ToNFA()
-	for i := 1; i < len(ctx.Tokens); i++ {
-		startNew, endNew := (ctx.Tokens[i]).ToNFA()
-		endOld.Transitions[utils.Epsilon] = append(endOld.Transitions[utils.Epsilon], startNew)
-		endOld = endNew
+	if err := CheckSupported(ctx.Tokens); err != nil {
+		return nil, err
 	}
+	classes := token.ByteClassesOf(ctx.Tokens)
+	startOld, endOld := token.ConcatToNFA(ctx.Tokens, classes)
 
 	initialGlobalState := &state.State{
-		Initial: true,
-		Final:   false,
-		Transitions: map[uint8][]*state.State{
-			utils.Epsilon: {startOld},
-		},
+		Initial:     true,
+		Final:       false,
+		Transitions: map[uint8][]*state.State{},
+		Epsilon:     []*state.State{startOld},
+		Classes:     classes,
 	}
 
 	finalGlobalState := &state.State{
@@ -36,7 +36,31 @@ func ToNFA(ctx *parser.ParseContext) (*state.State, error) {
 		Transitions: map[uint8][]*state.State{},
 	}
 
-	endOld.Transitions[utils.Epsilon] = append(endOld.Transitions[utils.Epsilon], finalGlobalState)
+	endOld.Epsilon = append(endOld.Epsilon, finalGlobalState)
 
 	return initialGlobalState, nil
 }
+
+/*
+CheckSupported walks the token tree and fails on the first token that
+an automaton cannot express, as it needs to backtrack:
+- atomic groups and possessive quantifiers
+- backreferences
+- lookaheads and lookbehinds
+*/
+func CheckSupported(tokens []token.Token) error {
+	for _, t := range tokens {
+		switch t.TokenType {
+		case token_type.Atomic:
+			return fmt.Errorf("atomic group or possessive quantifier at position %d is not supported by the NFA engine, use the backtracking engine", t.Span.Start)
+		case token_type.Backref:
+			return fmt.Errorf("backreference at position %d is not supported by the NFA engine, use the backtracking engine", t.Span.Start)
+		case token_type.Lookahead, token_type.Lookbehind:
+			return fmt.Errorf("lookaround at position %d is not supported by the NFA engine, use the backtracking engine", t.Span.Start)
+		}
+		if err := CheckSupported(t.Children()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
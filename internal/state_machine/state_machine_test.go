@@ -107,3 +107,48 @@ func TestToNFAValidTokens(t *testing.T) {
 		})
 	}
 }
+
+// TestByteClasses tests that the NFA transitions are keyed by the byte classes of the pattern
+func TestByteClasses(t *testing.T) {
+	tests := []struct {
+		regex   string
+		classes int
+		matches []string
+		misses  []string
+	}{
+		// [\x00-`], [a-z], [{-\x7f], the continuation bytes, the lead bytes
+		// of 2, 3 and 4 byte runes, and the invalid bytes
+		{"[a-z]+", 8, []string{"a", "xyz"}, []string{"", "x1", "{"}},
+		// [\x00-`], a, b, c, [d-\x7f], the continuation and lead bytes, and the invalid bytes
+		{"abc", 10, []string{"abc"}, []string{"abd", "bbc"}},
+		// K, k and the bytes \xe2\x84\xaa of the Kelvin sign split 6 classes apart
+		{"(?i)k", 16, []string{"k", "K", "K"}, []string{"j", "\xe2\x84\xab"}},
+		// [\x00-/], [0-9], [:-`], [a-z], [{-\x7f], the continuation and lead bytes, and the invalid bytes
+		{"[a-z]+[0-9]", 10, []string{"ab1", "z9"}, []string{"ab", "1"}},
+	}
+
+	for _, tt := range tests {
+		ctx, err := parser.Parse(tt.regex)
+		if err != nil {
+			t.Fatalf("Parse failed for %q: %v", tt.regex, err)
+		}
+		nfa, err := ToNFA(ctx)
+		if err != nil {
+			t.Fatalf("ToNFA failed for %q: %v", tt.regex, err)
+		}
+
+		if nfa.Classes.Len() != tt.classes {
+			t.Errorf("expected %d byte classes for %q, got %d", tt.classes, tt.regex, nfa.Classes.Len())
+		}
+		for _, input := range tt.matches {
+			if !nfa.Check(input) {
+				t.Errorf("expected %q to match %q", tt.regex, input)
+			}
+		}
+		for _, input := range tt.misses {
+			if nfa.Check(input) {
+				t.Errorf("expected %q not to match %q", tt.regex, input)
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"unicode/utf8"
 )
 
 const (
@@ -13,7 +14,6 @@ const (
 )
 
 const Infinite = -1
-const Epsilon uint8 = 0
 
 func GetChar(input string, pos int) uint8 {
 	if pos >= len(input) {
@@ -27,6 +27,37 @@ func GetChar(input string, pos int) uint8 {
 	return uint8(input[pos])
 }
 
+// IsWordChar reports whether ch is a word character: [0-9A-Za-z_]
+func IsWordChar(ch uint8) bool {
+	return ch >= '0' && ch <= '9' ||
+		ch >= 'a' && ch <= 'z' ||
+		ch >= 'A' && ch <= 'Z' ||
+		ch == '_'
+}
+
+/*
+IsInvalidByte reports whether the byte at pos in the input is invalid
+UTF-8, decoded as U+FFFD on its own as Go does: it neither starts a
+valid UTF-8 sequence nor is one of the continuation bytes of the valid
+sequence starting up to 3 bytes before it.
+ex.: in "\xe2\x84x", \xe2 and \x84 are invalid, in "\xe2\x84\xaa" none is
+*/
+func IsInvalidByte(input string, pos int) bool {
+	if input[pos] < utf8.RuneSelf {
+		return false
+	}
+	if r, size := utf8.DecodeRuneInString(input[pos:]); r != utf8.RuneError || size > 1 {
+		return false
+	}
+	for back := 1; back <= utf8.UTFMax-1 && back <= pos; back++ {
+		if utf8.RuneStart(input[pos-back]) {
+			_, size := utf8.DecodeRuneInString(input[pos-back:])
+			return size <= back
+		}
+	}
+	return true
+}
+
 func GetInput(message string) string {
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Println(message)